@@ -0,0 +1,148 @@
+// Package config fills in the flags a program leaves off its command line
+// from the environment and from a config file.
+//
+// A setting left off the command line comes from the environment, then from
+// the config file, and otherwise keeps the flag's default:
+//
+//	defaults < config file < environment < flags
+//
+// The environment variable of a setting is its flag name in upper case with
+// the program's prefix, so -read-timeout is MOVIES_READ_TIMEOUT for the
+// prefix "MOVIES_". In the config file it is the flag name, where "_" may
+// stand for "-" and sections are joined to their keys with "-":
+//
+//	read:
+//	  timeout: 10s   # -read-timeout
+//
+// Secrets, such as keys, are only taken from the environment and the config
+// file: other users of the machine can see a command line.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Where the value of a setting came from.
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+)
+
+// Config is the outcome of Load.
+type Config struct {
+	Print bool // -print-config was given
+
+	fs      *flag.FlagSet
+	prefix  string            // of the environment variables
+	file    string            // the config file, if any
+	sources map[string]string // flag name => where its value came from
+	secrets map[string]bool   // flag names
+}
+
+// Load parses args into fs, and fills in the settings they leave out from
+// the environment variables starting with prefix and the config file. It
+// adds two flags of its own: -config names the config file (it can also
+// come from the environment, as <prefix>CONFIG) and -print-config asks for
+// the effective config to be printed, which only the command line can do.
+//
+// The flags named in secrets are refused on the command line, and redacted
+// by Write.
+func Load(fs *flag.FlagSet, args []string, prefix string, secrets ...string) (*Config, error) {
+	c := &Config{fs: fs, prefix: prefix, sources: map[string]string{}, secrets: map[string]bool{}}
+	for _, name := range secrets {
+		c.secrets[name] = true
+	}
+	fs.StringVar(&c.file, "config", "", "YAML, TOML or JSON config file")
+	fs.BoolVar(&c.Print, "print-config", false, "print the effective config, secrets redacted, and exit")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	var errs []error
+	fs.Visit(func(f *flag.Flag) {
+		c.sources[f.Name] = sourceFlag
+		if c.secrets[f.Name] {
+			errs = append(errs, fmt.Errorf("-%s: a secret can't be given on the command line, set %s or put it in the config file",
+				f.Name, c.envName(f.Name)))
+		}
+	})
+	if errs != nil {
+		return nil, errors.Join(errs...)
+	}
+	if c.sources["config"] == "" {
+		if file, ok := os.LookupEnv(c.envName("config")); ok {
+			c.file, c.sources["config"] = file, sourceEnv
+		}
+	}
+	fileValues := map[string]string{}
+	if c.file != "" {
+		var err error
+		if fileValues, err = readConfigFile(c.file); err != nil {
+			return nil, err
+		}
+	}
+
+	for name := range fileValues {
+		if f := fs.Lookup(name); f == nil || name == "config" || name == "print-config" {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %q", c.file, name))
+		}
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		if c.sources[f.Name] != "" {
+			return
+		}
+		c.sources[f.Name] = sourceDefault
+		if f.Name == "config" || f.Name == "print-config" {
+			return // a config file doesn't name another, nor ask to be printed
+		}
+		if value, ok := os.LookupEnv(c.envName(f.Name)); ok {
+			c.sources[f.Name] = sourceEnv
+			if err := f.Value.Set(value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid value %q: %v", c.envName(f.Name), value, err))
+			}
+		} else if value, ok := fileValues[f.Name]; ok {
+			c.sources[f.Name] = sourceFile
+			if err := f.Value.Set(value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid value %q for %s: %v", c.file, value, f.Name, err))
+			}
+		}
+	})
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return c, errors.Join(errs...)
+}
+
+// envName returns the environment variable of a setting.
+func (c *Config) envName(name string) string {
+	return c.prefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Write prints every setting with its value and where the value came from,
+// as YAML that works as a config file. The values of secrets are replaced, so
+// the output is safe to share.
+func (c *Config) Write(w io.Writer) {
+	if c.file != "" {
+		fmt.Fprintf(w, "# config file: %s (%s)\n", c.file, c.sources["config"])
+	}
+	c.fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || f.Name == "print-config" {
+			return
+		}
+		value := f.Value.String()
+		if c.secrets[f.Name] && value != "" {
+			value = "[redacted]"
+		}
+		fmt.Fprintf(w, "%-22s %-28s # %s\n", f.Name+":", strconv.Quote(value), c.sources[f.Name])
+	})
+}
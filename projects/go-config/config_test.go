@@ -0,0 +1,197 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFlags are the settings of a small program.
+type testFlags struct {
+	addr    string
+	timeout time.Duration
+	level   string
+	secret  string
+	verbose bool
+}
+
+func (f *testFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.addr, "addr", ":8080", "")
+	fs.DurationVar(&f.timeout, "read-timeout", time.Second, "")
+	fs.StringVar(&f.level, "log-level", "info", "")
+	fs.StringVar(&f.secret, "jwt-hs256-secret", "", "")
+	fs.BoolVar(&f.verbose, "verbose", false, "")
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func load(t *testing.T, args ...string) (*testFlags, *Config, error) {
+	t.Helper()
+	var f testFlags
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(&bytes.Buffer{})
+	f.register(fs)
+	c, err := Load(fs, args, "TEST_", "jwt-hs256-secret")
+	return &f, c, err
+}
+
+// Flags win over the environment, which wins over the config file.
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "c.yaml", "addr: :9000\nlog_level: debug\nread:\n  timeout: 5s\n")
+	t.Setenv("TEST_CONFIG", file)
+	t.Setenv("TEST_LOG_LEVEL", "warn")
+
+	f, c, err := load(t, "-addr", ":7000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := testFlags{addr: ":7000", timeout: 5 * time.Second, level: "warn"}
+	if *f != want {
+		t.Errorf("got %+v, want %+v", *f, want)
+	}
+	sources := map[string]string{"addr": "flag", "read-timeout": "file", "log-level": "env", "verbose": "default", "config": "env"}
+	for name, source := range sources {
+		if c.sources[name] != source {
+			t.Errorf("%s comes from %q, want %q", name, c.sources[name], source)
+		}
+	}
+}
+
+// The three formats give the same settings.
+func TestConfigFormats(t *testing.T) {
+	want := map[string]string{
+		"addr": ":9000", "read-timeout": "5s", "log-level": "de#bug", "verbose": "true", "jwt-hs256-secret": `it's "quoted"`,
+	}
+	files := map[string]string{
+		"c.json": `{"addr": ":9000", "read": {"timeout": "5s"}, "log_level": "de#bug", "verbose": true,
+			"jwt": {"hs256": {"secret": "it's \"quoted\""}}, "unset": null}`,
+		"c.yaml": `---
+# comment
+addr: :9000   # comment
+read:
+  timeout: "5s"
+log_level: 'de#bug'
+verbose: true
+jwt:
+  hs256:
+    secret: 'it''s "quoted"'
+`,
+		"c.toml": `addr = ":9000" # comment
+log_level = "de#bug"
+verbose = true
+
+[read]
+timeout = '5s'
+
+[jwt.hs256]
+secret = "it's \"quoted\""
+`,
+	}
+	for name, content := range files {
+		got, err := readConfigFile(writeFile(t, name, content))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	files := map[string]string{
+		"list.yaml":     "addr:\n  - a\n",
+		"tabs.yaml":     "read:\n\ttimeout: 5s\n",
+		"twice.yaml":    "read_timeout: 5s\nread:\n  timeout: 6s\n",
+		"open.yaml":     "addr: \"oops\n",
+		"array.toml":    "addr = [1]\n",
+		"header.toml":   "[[addr]]\n",
+		"multi.toml":    "addr = \"\"\"x\"\"\"\n",
+		"list.json":     `{"addr": [":80"]}`,
+		"format.ini":    "addr = :80\n",
+		"trailing.toml": "addr = \"x\" y\n",
+	}
+	for name, content := range files {
+		if _, err := readConfigFile(writeFile(t, name, content)); err == nil {
+			t.Errorf("%s was accepted", name)
+		}
+	}
+
+	// unknown settings and bad values are all reported at once
+	file := writeFile(t, "c.yaml", "adress: :80\nread_timeout: soon\nconfig: other.yaml\n")
+	_, _, err := load(t, "-config", file)
+	if err == nil {
+		t.Fatal("Load accepted a bad config file")
+	}
+	for _, want := range []string{`unknown setting "adress"`, `unknown setting "config"`, `invalid value "soon"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't mention %s", err, want)
+		}
+	}
+	t.Setenv("TEST_VERBOSE", "maybe")
+	if _, _, err := load(t); err == nil || !strings.Contains(err.Error(), "TEST_VERBOSE") {
+		t.Errorf("a bad environment variable gave %v", err)
+	}
+	if _, _, err := load(t, "extra"); err == nil {
+		t.Error("Load accepted an argument")
+	}
+}
+
+// Secrets are refused on the command line, and only there; actions are only
+// taken from it.
+func TestCommandLineOnly(t *testing.T) {
+	_, _, err := load(t, "-jwt-hs256-secret", "hunter2")
+	if err == nil || !strings.Contains(err.Error(), "TEST_JWT_HS256_SECRET") {
+		t.Errorf("a secret on the command line gave %v", err)
+	}
+	t.Setenv("TEST_JWT_HS256_SECRET", "hunter2")
+	t.Setenv("TEST_PRINT_CONFIG", "true")
+	f, c, err := load(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.secret != "hunter2" || c.sources["jwt-hs256-secret"] != "env" {
+		t.Errorf("secret %q from %q, want it from the environment", f.secret, c.sources["jwt-hs256-secret"])
+	}
+	if c.Print {
+		t.Error("TEST_PRINT_CONFIG set Print")
+	}
+}
+
+// Write prints a config file, with the secrets redacted.
+func TestWrite(t *testing.T) {
+	file := writeFile(t, "c.toml", "[jwt.hs256]\nsecret = \"hunter2\"\n")
+	_, c, err := load(t, "-config", file, "-print-config", "-verbose")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Print {
+		t.Error("Print is not set")
+	}
+	var out bytes.Buffer
+	c.Write(&out)
+	if strings.Contains(out.String(), "hunter2") {
+		t.Errorf("the secret is printed:\n%s", out.String())
+	}
+	printed := writeFile(t, "printed.yaml", out.String())
+	values, err := readConfigFile(printed)
+	if err != nil {
+		t.Fatalf("the output is not a config file: %v\n%s", err, out.String())
+	}
+	if values["verbose"] != "true" || values["jwt-hs256-secret"] != "[redacted]" || values["read-timeout"] != "1s" {
+		t.Errorf("printed %v", values)
+	}
+}
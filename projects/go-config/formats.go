@@ -0,0 +1,263 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// readConfigFile reads the settings of a config file, by flag name. The
+// format follows the extension: .json, .yaml, .yml or .toml. Values are
+// strings, numbers or booleans; lists are not supported.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	values := map[string]string{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		err = parseJSONConfig(data, values)
+	case ".yaml", ".yml":
+		err = parseYAMLConfig(data, values)
+	case ".toml":
+		err = parseTOMLConfig(data, values)
+	default:
+		return nil, fmt.Errorf("%s: config files must be .json, .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return values, nil
+}
+
+// settingName turns a key of a config file, under section, into the name
+// of a flag.
+func settingName(section, key string) string {
+	key = strings.ReplaceAll(key, "_", "-")
+	if section == "" {
+		return key
+	}
+	return section + "-" + key
+}
+
+// setValue records one setting, refusing to set it twice.
+func setValue(values map[string]string, name, value string) error {
+	if _, dup := values[name]; dup {
+		return fmt.Errorf("%s is set twice", name)
+	}
+	values[name] = value
+	return nil
+}
+
+func parseJSONConfig(data []byte, values map[string]string) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var root map[string]any
+	if err := dec.Decode(&root); err != nil {
+		return err
+	}
+	var walk func(section string, obj map[string]any) error
+	walk = func(section string, obj map[string]any) error {
+		for key, v := range obj {
+			name := settingName(section, key)
+			var err error
+			switch v := v.(type) {
+			case map[string]any:
+				err = walk(name, v)
+			case string:
+				err = setValue(values, name, v)
+			case json.Number:
+				err = setValue(values, name, v.String())
+			case bool:
+				err = setValue(values, name, strconv.FormatBool(v))
+			case nil:
+				// null leaves the setting alone
+			default:
+				err = fmt.Errorf("%s: lists are not supported", name)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk("", root)
+}
+
+// parseYAMLConfig reads the part of YAML a config file needs: "key: value"
+// lines, nested by indentation, with plain, single or double quoted values
+// and # comments.
+func parseYAMLConfig(data []byte, values map[string]string) error {
+	type section struct {
+		indent int
+		name   string
+	}
+	sections := []section{{indent: -1}}
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		content := strings.TrimLeft(line, " ")
+		if content == "" || content[0] == '#' || content == "---" {
+			continue
+		}
+		fail := func(format string, args ...any) error {
+			return fmt.Errorf("line %d: %s", n+1, fmt.Sprintf(format, args...))
+		}
+		switch {
+		case content[0] == '\t':
+			return fail("indent with spaces, not tabs")
+		case strings.HasPrefix(content, "- "):
+			return fail("lists are not supported")
+		}
+
+		indent := len(line) - len(content)
+		for indent <= sections[len(sections)-1].indent {
+			sections = sections[:len(sections)-1]
+		}
+		key, rest, ok := strings.Cut(content, ":")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || (rest != "" && rest[0] != ' ') {
+			return fail("want \"key: value\"")
+		}
+		name := settingName(sections[len(sections)-1].name, key)
+		rest = strings.TrimSpace(rest)
+		if rest == "" || rest[0] == '#' {
+			// the start of a section, whose keys are indented below it
+			sections = append(sections, section{indent: indent, name: name})
+			continue
+		}
+		value, err := yamlScalar(rest)
+		if err != nil {
+			return fail("%s: %v", key, err)
+		}
+		if err := setValue(values, name, value); err != nil {
+			return fail("%v", err)
+		}
+	}
+	return nil
+}
+
+// yamlScalar returns the value of a YAML scalar, without its quotes or
+// comment.
+func yamlScalar(s string) (string, error) {
+	switch {
+	case s[0] == '"' || s[0] == '\'':
+		return quotedValue(s, true)
+	case s[0] == '[' || s[0] == '{':
+		return "", errors.New("lists and inline maps are not supported")
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s), nil
+}
+
+// parseTOMLConfig reads the part of TOML a config file needs: [section]
+// headers and "key = value" lines with strings, numbers and booleans, and #
+// comments.
+func parseTOMLConfig(data []byte, values map[string]string) error {
+	section := ""
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fail := func(format string, args ...any) error {
+			return fmt.Errorf("line %d: %s", n+1, fmt.Sprintf(format, args...))
+		}
+		if line[0] == '[' {
+			header, _, _ := strings.Cut(line, "#")
+			header = strings.TrimSpace(header)
+			if strings.HasPrefix(header, "[[") || !strings.HasSuffix(header, "]") {
+				return fail("want a [section] header")
+			}
+			section = strings.ReplaceAll(strings.TrimSpace(header[1:len(header)-1]), ".", "-")
+			if section == "" {
+				return fail("empty section name")
+			}
+			continue
+		}
+
+		key, rest, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		rest = strings.TrimSpace(rest)
+		if !ok || key == "" {
+			return fail("want \"key = value\"")
+		}
+		var value string
+		var err error
+		switch {
+		case strings.HasPrefix(rest, `"""`) || strings.HasPrefix(rest, "'''"):
+			err = errors.New("multi-line strings are not supported")
+		case strings.HasPrefix(rest, `"`) || strings.HasPrefix(rest, "'"):
+			value, err = quotedValue(rest, false)
+		case strings.HasPrefix(rest, "[") || strings.HasPrefix(rest, "{"):
+			err = errors.New("arrays and inline tables are not supported")
+		default:
+			value, _, _ = strings.Cut(rest, "#")
+			value = strings.ReplaceAll(strings.TrimSpace(value), "_", "") // 1_000
+			if value == "" {
+				err = errors.New("missing value")
+			}
+		}
+		if err != nil {
+			return fail("%s: %v", key, err)
+		}
+		name := settingName(section, strings.ReplaceAll(key, ".", "-"))
+		if err := setValue(values, name, value); err != nil {
+			return fail("%v", err)
+		}
+	}
+	return nil
+}
+
+// quotedValue returns the string that s starts with, which is in double
+// quotes (with backslash escapes) or single quotes (taken literally, except
+// that YAML doubles a single quote inside them). Only a comment may follow
+// it.
+func quotedValue(s string, yaml bool) (string, error) {
+	var value string
+	end := -1
+	if s[0] == '"' {
+		for i := 1; i < len(s); i++ {
+			if s[i] == '\\' {
+				i++
+			} else if s[i] == '"' {
+				end = i + 1
+				break
+			}
+		}
+		if end > 0 {
+			var err error
+			if value, err = strconv.Unquote(s[:end]); err != nil {
+				return "", fmt.Errorf("bad string %s", s[:end])
+			}
+		}
+	} else {
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			if s[i] != '\'' {
+				b.WriteByte(s[i])
+			} else if yaml && i+1 < len(s) && s[i+1] == '\'' {
+				b.WriteByte('\'')
+				i++
+			} else {
+				end = i + 1
+				break
+			}
+		}
+		value = b.String()
+	}
+	if end < 0 {
+		return "", errors.New("unterminated string")
+	}
+	if rest := strings.TrimSpace(s[end:]); rest != "" && rest[0] != '#' {
+		return "", fmt.Errorf("unexpected %q after the string", rest)
+	}
+	return value, nil
+}
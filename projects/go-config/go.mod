@@ -0,0 +1,3 @@
+module go-config
+
+go 1.21
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Identity is who a request was authenticated as.
+type Identity struct {
+	Name   string // the API key's name or the token's subject
+	Method string // "api-key" or "jwt"
+}
+
+// Authenticator checks one kind of credentials. ok is false when the request
+// carries none of that kind, so the next authenticator can have a look; err
+// is set when it does carry them but they are wrong.
+type Authenticator interface {
+	Authenticate(r *http.Request) (id Identity, ok bool, err error)
+	// Challenge is sent in WWW-Authenticate when a request is refused.
+	Challenge() string
+}
+
+// authConfig says where the credentials come from. Every file is optional;
+// with none of them, authentication is off.
+type authConfig struct {
+	APIKeysFile  string // API keys, one "name key" pair per line
+	HS256KeyFile string // shared secret for HS256 tokens
+	HS256Secret  string // the same secret given directly, instead of in a file
+	RS256KeyFile string // PEM public key (or certificate) for RS256 tokens
+	Issuer       string // if set, tokens must have this "iss"
+	Audience     string // if set, tokens must have this "aud"
+	PublicReads  bool   // let GET requests through without credentials
+}
+
+// authPolicy is the authentication middleware.
+type authPolicy struct {
+	authenticators []Authenticator
+	publicReads    bool
+}
+
+// policy loads the keys named in c. It returns nil when no keys are
+// configured, which leaves every route open.
+func (c authConfig) policy() (*authPolicy, error) {
+	p := &authPolicy{publicReads: c.PublicReads}
+	if c.APIKeysFile != "" {
+		keys, err := loadAPIKeys(c.APIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		p.authenticators = append(p.authenticators, keys)
+	}
+	if c.HS256KeyFile != "" || c.HS256Secret != "" || c.RS256KeyFile != "" {
+		jwt := &jwtAuthenticator{issuer: c.Issuer, audience: c.Audience}
+		var err error
+		if c.HS256Secret != "" {
+			jwt.hmacKey = []byte(c.HS256Secret)
+		}
+		if c.HS256KeyFile != "" {
+			if jwt.hmacKey, err = loadHMACKey(c.HS256KeyFile); err != nil {
+				return nil, err
+			}
+		}
+		if c.RS256KeyFile != "" {
+			if jwt.rsaKey, err = loadRSAPublicKey(c.RS256KeyFile); err != nil {
+				return nil, err
+			}
+		}
+		p.authenticators = append(p.authenticators, jwt)
+	}
+	if len(p.authenticators) == 0 {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// identify checks the credentials of every request, once, and notes who
+// they belong to in its context, for the rate limiter and require. It
+// refuses nothing: a request with bad credentials goes on with the error
+// noted instead.
+func (p *authPolicy) identify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok, err := p.authenticate(r)
+		ctx := r.Context()
+		if err != nil {
+			ctx = context.WithValue(ctx, authErrorKey{}, err)
+		} else if ok {
+			ctx = context.WithValue(ctx, identityKey{}, id)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// require refuses requests without valid credentials with a 401, except
+// reads when publicReads is set. Credentials that are sent are always
+// checked, even on a public read. It goes after identify.
+func (p *authPolicy) require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err, _ := r.Context().Value(authErrorKey{}).(error); err != nil {
+			p.unauthorized(w, err.Error())
+			return
+		}
+		if _, ok := identityOf(r); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if p.publicReads && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p.unauthorized(w, "authentication required")
+	})
+}
+
+// authenticate returns who the credentials of r belong to. ok is false when
+// r has no credentials.
+func (p *authPolicy) authenticate(r *http.Request) (id Identity, ok bool, err error) {
+	for _, a := range p.authenticators {
+		if id, ok, err = a.Authenticate(r); ok || err != nil {
+			return id, ok, err
+		}
+	}
+	return Identity{}, false, nil
+}
+
+func (p *authPolicy) unauthorized(w http.ResponseWriter, message string) {
+	for _, a := range p.authenticators {
+		w.Header().Add("WWW-Authenticate", a.Challenge())
+	}
+	writeError(w, http.StatusUnauthorized, codeUnauthorized, message)
+}
+
+type (
+	identityKey  struct{}
+	authErrorKey struct{}
+)
+
+// identityOf returns who r was authenticated as, if anyone.
+func identityOf(r *http.Request) (Identity, bool) {
+	id, ok := r.Context().Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// apiKeyAuthenticator accepts the keys of its file in the X-API-Key header.
+// Keys are looked up by their SHA-256 hash, so lookups take the same time
+// however much of a guess is right.
+type apiKeyAuthenticator map[[sha256.Size]byte]string
+
+// minAPIKeyLength keeps keys long enough that guessing them is hopeless.
+const minAPIKeyLength = 16
+
+// loadAPIKeys reads a file of "name key" lines. Blank lines and lines
+// starting with # are skipped.
+func loadAPIKeys(path string) (apiKeyAuthenticator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading API keys: %w", err)
+	}
+	keys := apiKeyAuthenticator{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want a name and a key", path, n)
+		}
+		name, key := fields[0], fields[1]
+		if len(key) < minAPIKeyLength {
+			return nil, fmt.Errorf("%s:%d: the key of %q must be at least %d characters", path, n, name, minAPIKeyLength)
+		}
+		hash := sha256.Sum256([]byte(key))
+		if _, taken := keys[hash]; taken {
+			return nil, fmt.Errorf("%s:%d: the key of %q is also used by %q", path, n, name, keys[hash])
+		}
+		keys[hash] = name
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading API keys: %w", err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s has no API keys", path)
+	}
+	return keys, nil
+}
+
+func (keys apiKeyAuthenticator) Authenticate(r *http.Request) (Identity, bool, error) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		return Identity{}, false, nil
+	}
+	name, ok := keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return Identity{}, false, errors.New("invalid API key")
+	}
+	return Identity{Name: name, Method: "api-key"}, true, nil
+}
+
+func (apiKeyAuthenticator) Challenge() string {
+	return `APIKey realm="movies", header="X-API-Key"`
+}
+
+// jwtAuthenticator accepts JSON Web Tokens in an "Authorization: Bearer"
+// header, signed with HS256 or RS256. Only the algorithms with a key are
+// accepted: the token's "alg" picks the key, never the other way round.
+type jwtAuthenticator struct {
+	hmacKey  []byte
+	rsaKey   *rsa.PublicKey
+	issuer   string
+	audience string
+}
+
+// jwtLeeway allows for clocks that are a little out of step when checking
+// "exp" and "nbf".
+const jwtLeeway = 30 * time.Second
+
+// minHMACKeyLength is the size of the SHA-256 output: a shorter HS256 secret
+// weakens the signature.
+const minHMACKeyLength = 32
+
+// loadHMACKey reads an HS256 secret. Surrounding whitespace, such as a final
+// newline, is not part of it.
+func loadHMACKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading HS256 key: %w", err)
+	}
+	key := bytes.TrimSpace(data)
+	if len(key) < minHMACKeyLength {
+		return nil, fmt.Errorf("%s: the HS256 key must be at least %d bytes", path, minHMACKeyLength)
+	}
+	return key, nil
+}
+
+// loadRSAPublicKey reads a PEM "PUBLIC KEY", "RSA PUBLIC KEY" or
+// "CERTIFICATE".
+func loadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading RS256 key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	var key any
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("%s: want a PUBLIC KEY, RSA PUBLIC KEY or CERTIFICATE, not %s", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an RSA key", path)
+	}
+	return rsaKey, nil
+}
+
+func (j *jwtAuthenticator) Authenticate(r *http.Request) (Identity, bool, error) {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return Identity{}, false, nil
+	}
+	subject, err := j.verify(strings.TrimSpace(token), time.Now())
+	if err != nil {
+		return Identity{}, false, fmt.Errorf("invalid token: %w", err)
+	}
+	return Identity{Name: subject, Method: "jwt"}, true, nil
+}
+
+func (j *jwtAuthenticator) Challenge() string {
+	return `Bearer realm="movies"`
+}
+
+// verify checks the signature and claims of a token at time now, and
+// returns its subject.
+func (j *jwtAuthenticator) verify(token string, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("not a JWT")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.New("malformed signature")
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	switch {
+	case header.Alg == "HS256" && j.hmacKey != nil:
+		mac := hmac.New(sha256.New, j.hmacKey)
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return "", errors.New("bad signature")
+		}
+	case header.Alg == "RS256" && j.rsaKey != nil:
+		digest := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(j.rsaKey, crypto.SHA256, digest[:], signature) != nil {
+			return "", errors.New("bad signature")
+		}
+	default:
+		return "", fmt.Errorf("algorithm %q is not accepted", header.Alg)
+	}
+
+	var claims struct {
+		Subject   string          `json:"sub"`
+		Issuer    string          `json:"iss"`
+		Audience  json.RawMessage `json:"aud"` // a string or an array of them
+		ExpiresAt *float64        `json:"exp"`
+		NotBefore *float64        `json:"nbf"`
+	}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", err
+	}
+	if claims.ExpiresAt != nil && now.Add(-jwtLeeway).After(unixTime(*claims.ExpiresAt)) {
+		return "", errors.New("expired")
+	}
+	if claims.NotBefore != nil && now.Add(jwtLeeway).Before(unixTime(*claims.NotBefore)) {
+		return "", errors.New("not valid yet")
+	}
+	if j.issuer != "" && claims.Issuer != j.issuer {
+		return "", errors.New("wrong issuer")
+	}
+	if j.audience != "" && !hasAudience(claims.Audience, j.audience) {
+		return "", errors.New("wrong audience")
+	}
+	if claims.Subject == "" {
+		return "", errors.New("no subject")
+	}
+	return claims.Subject, nil
+}
+
+// decodeSegment decodes the base64url JSON of a token's header or claims.
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errors.New("malformed token")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.New("malformed token")
+	}
+	return nil
+}
+
+// unixTime converts a JWT NumericDate, seconds since the epoch, to a time.
+func unixTime(seconds float64) time.Time {
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*1e9))
+}
+
+func hasAudience(raw json.RawMessage, want string) bool {
+	var one string
+	if json.Unmarshal(raw, &one) == nil {
+		return one == want
+	}
+	var many []string
+	json.Unmarshal(raw, &many)
+	for _, aud := range many {
+		if aud == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testAPIKey = "0123456789abcdef0123"
+	testSecret = "an HS256 secret of at least 32 bytes"
+)
+
+// writeFile writes content to a file in dir and returns its path.
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// signToken returns a JWT with the given claims, signed with HS256 using
+// secret, or with RS256 using key if it is set.
+func signToken(t *testing.T, claims map[string]any, secret string, key *rsa.PrivateKey) string {
+	t.Helper()
+	alg := "HS256"
+	if key != nil {
+		alg = "RS256"
+	}
+	segment := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := segment(map[string]string{"alg": alg, "typ": "JWT"}) + "." + segment(claims)
+
+	var signature []byte
+	if key != nil {
+		digest := sha256.Sum256([]byte(signed))
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// newAuthServer returns the routes of a test server with authentication
+// set up from c.
+func newAuthServer(t *testing.T, c authConfig) http.Handler {
+	t.Helper()
+	policy, err := c.policy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestServer(t)
+	srv.auth = policy
+	return srv.routes()
+}
+
+func TestAPIKeys(t *testing.T) {
+	dir := t.TempDir()
+	keys := writeFile(t, dir, "keys", "# name key\nalice "+testAPIKey+"\n")
+	h := newAuthServer(t, authConfig{APIKeysFile: keys})
+
+	resp := do(t, h, "GET", "/movies", "")
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("no key: status %d, want 401", resp.StatusCode)
+	}
+	if got := resp.Header.Get("WWW-Authenticate"); !strings.HasPrefix(got, "APIKey") {
+		t.Errorf("WWW-Authenticate = %q, want an APIKey challenge", got)
+	}
+	if resp := do(t, h, "GET", "/movies", "", "X-API-Key", testAPIKey+"x"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("wrong key: status %d, want 401", resp.StatusCode)
+	}
+	if resp := do(t, h, "PATCH", "/movies/1", `{"title":"A New Hope"}`, "X-API-Key", testAPIKey); resp.StatusCode != http.StatusOK {
+		t.Fatalf("right key: status %d, want 200", resp.StatusCode)
+	}
+
+	// the history names the key, whatever X-Actor says
+	var events []MovieEvent
+	decodeBody(t, do(t, h, "GET", "/movies/1/history", "", "X-API-Key", testAPIKey, "X-Actor", "mallory"), &events)
+	if last := events[len(events)-1]; last.Actor != "alice" {
+		t.Errorf("actor = %q, want alice", last.Actor)
+	}
+
+	for name, content := range map[string]string{
+		"short":     "alice short\n",
+		"duplicate": "alice " + testAPIKey + "\nbob " + testAPIKey + "\n",
+		"fields":    "alice\n",
+		"empty":     "# nobody\n",
+	} {
+		if _, err := loadAPIKeys(writeFile(t, dir, name, content)); err == nil {
+			t.Errorf("loadAPIKeys accepted a file with %s keys", name)
+		}
+	}
+}
+
+func TestPublicReads(t *testing.T) {
+	keys := writeFile(t, t.TempDir(), "keys", "alice "+testAPIKey+"\n")
+	h := newAuthServer(t, authConfig{APIKeysFile: keys, PublicReads: true})
+
+	if resp := do(t, h, "GET", "/movies", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("anonymous read: status %d, want 200", resp.StatusCode)
+	}
+	if resp := do(t, h, "DELETE", "/movies/1", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("anonymous write: status %d, want 401", resp.StatusCode)
+	}
+	// credentials that are sent are checked, even on a public read
+	if resp := do(t, h, "GET", "/movies", "", "X-API-Key", "not the key at all"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("read with a wrong key: status %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestJWT(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	pub := writeFile(t, dir, "pub.pem", string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+	h := newAuthServer(t, authConfig{HS256Secret: testSecret, RS256KeyFile: pub, Issuer: "tests", Audience: "movies"})
+
+	now := time.Now().Unix()
+	valid := map[string]any{"sub": "bob", "iss": "tests", "aud": []string{"other", "movies"}, "exp": now + 60}
+	with := func(name string, value any) map[string]any {
+		claims := map[string]any{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"HS256", signToken(t, valid, testSecret, nil), http.StatusOK},
+		{"RS256", signToken(t, valid, "", key), http.StatusOK},
+		{"wrong secret", signToken(t, valid, testSecret+"!", nil), http.StatusUnauthorized},
+		{"expired", signToken(t, with("exp", now-3600), testSecret, nil), http.StatusUnauthorized},
+		{"not yet valid", signToken(t, with("nbf", now+3600), testSecret, nil), http.StatusUnauthorized},
+		{"wrong issuer", signToken(t, with("iss", "someone"), testSecret, nil), http.StatusUnauthorized},
+		{"wrong audience", signToken(t, with("aud", "others"), testSecret, nil), http.StatusUnauthorized},
+		{"no subject", signToken(t, with("sub", nil), testSecret, nil), http.StatusUnauthorized},
+		{"no signature", strings.Join(strings.Split(signToken(t, valid, testSecret, nil), ".")[:2], ".") + ".", http.StatusUnauthorized},
+		{"garbage", "not.a.token", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		resp := do(t, h, "GET", "/movies", "", "Authorization", "Bearer "+tt.token)
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// maxBatchOperations caps the number of operations in one batch.
+const maxBatchOperations = 1000
+
+// Operations a batch can contain.
+const (
+	batchCreate = "create"
+	batchUpdate = "update"
+	batchDelete = "delete"
+)
+
+// batchOperation is one entry of a batch. It does what the matching request
+// would: POST /movies, PUT /movies/{id} or DELETE /movies/{id}.
+type batchOperation struct {
+	Op      string `json:"op"`
+	ID      string `json:"id,omitempty"`      // update and delete
+	IfMatch string `json:"ifMatch,omitempty"` // like the If-Match header
+	Movie   *Movie `json:"movie,omitempty"`   // create and update
+}
+
+// batchResult is what one operation did, with the status code its own
+// request would have got.
+type batchResult struct {
+	Index  int       `json:"index"`
+	Op     string    `json:"op"`
+	Status int       `json:"status"`
+	Movie  *Movie    `json:"movie,omitempty"`
+	Error  *apiError `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Committed bool          `json:"committed"`
+	Results   []batchResult `json:"results"`
+	// why the batch was rolled back, so the response is also a regular
+	// error response
+	Error *apiError `json:"error,omitempty"`
+}
+
+// errRollback makes Atomically undo a batch after an operation failed.
+var errRollback = errors.New("batch rolled back")
+
+// batchMovies handles POST /movies:batch. The operations run in order, in a
+// single transaction: if one fails, none of them are applied.
+//
+//	{"operations": [
+//	  {"op": "create", "movie": {...}},
+//	  {"op": "update", "id": "1", "ifMatch": "\"3-5a1f...\"", "movie": {...}},
+//	  {"op": "delete", "id": "2"}
+//	]}
+//
+// The response has one result per operation. When they all succeed it is a
+// 200 with "committed": true. Otherwise it has the status of the operation
+// that failed and that operation's error; the others get 424.
+func (s *server) batchMovies(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Operations []batchOperation `json:"operations"`
+	}
+	unknown, ok := decodeJSON(w, r, &req)
+	if !ok {
+		return
+	}
+	if errs := append(unknown, s.validateBatch(req.Operations)...); errs != nil {
+		writeValidationError(w, errs)
+		return
+	}
+	if err := s.authorizeBatch(r, req.Operations); err != nil {
+		writeError(w, http.StatusForbidden, codeForbidden, err.Error())
+		return
+	}
+
+	results := make([]batchResult, len(req.Operations))
+	failed := -1
+	err := s.store.Atomically(func(tx Store) error {
+		txs := s.in(tx)
+		for i, op := range req.Operations {
+			results[i] = txs.runBatchOperation(r, op)
+			results[i].Index, results[i].Op = i, op.Op
+			if results[i].Error != nil {
+				failed = i
+				return errRollback
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errRollback) {
+		serverError(w, r, err)
+		return
+	}
+
+	if failed < 0 {
+		writeJSON(w, http.StatusOK, batchResponse{Committed: true, Results: results})
+		return
+	}
+	cause := results[failed]
+	for i := range results {
+		if i != failed {
+			results[i] = batchResult{
+				Index:  i,
+				Op:     req.Operations[i].Op,
+				Status: http.StatusFailedDependency,
+				Error: &apiError{
+					Code:    codeFailedDependency,
+					Message: "not applied: operation " + strconv.Itoa(failed) + " failed",
+				},
+			}
+		}
+	}
+	batchErr := *cause.Error
+	batchErr.Message = fmt.Sprintf("operation %d failed, nothing was applied: %s", failed, batchErr.Message)
+	batchErr.RequestID = w.Header().Get(requestIDHeader)
+	writeJSON(w, cause.Status, batchResponse{Results: results, Error: &batchErr})
+}
+
+// validateBatch checks the shape of every operation, and the movie of every
+// create and update, before any of them runs.
+func (s *server) validateBatch(ops []batchOperation) []fieldError {
+	var errs []fieldError
+	if len(ops) == 0 {
+		errs = append(errs, fieldError{Field: "operations", Message: "is required"})
+	}
+	if len(ops) > maxBatchOperations {
+		errs = append(errs, fieldError{Field: "operations", Message: fmt.Sprintf("must have at most %d entries", maxBatchOperations)})
+	}
+	for i, op := range ops {
+		prefix := fmt.Sprintf("operations[%d].", i)
+		add := func(field, message string) {
+			errs = append(errs, fieldError{Field: prefix + field, Message: message})
+		}
+		switch op.Op {
+		case batchCreate, batchUpdate, batchDelete:
+		default:
+			add("op", "must be create, update or delete")
+			continue
+		}
+		if op.Op != batchCreate && op.ID == "" {
+			add("id", "is required")
+		}
+		if op.Op == batchCreate && op.ID != "" {
+			add("id", "goes in the movie for a create")
+		}
+		if op.Op == batchDelete {
+			if op.Movie != nil {
+				add("movie", "is not allowed for a delete")
+			}
+			continue
+		}
+		if op.Movie == nil {
+			add("movie", "is required")
+			continue
+		}
+		movieErrs := op.Movie.validate()
+		if op.Op == batchUpdate {
+			movieErrs = s.validateUpdate(op.ID, *op.Movie)
+		}
+		for _, e := range movieErrs {
+			add("movie."+e.Field, e.Message)
+		}
+	}
+	return errs
+}
+
+// batchRoutes are the routes whose permission each operation needs.
+var batchRoutes = map[string][2]string{
+	batchCreate: {"POST", "/movies"},
+	batchUpdate: {"PUT", "/movies/{id}"},
+	batchDelete: {"DELETE", "/movies/{id}"},
+}
+
+// authorizeBatch checks that the user's role allows every operation on its
+// own, so a batch can't do what the matching requests wouldn't be allowed to.
+func (s *server) authorizeBatch(r *http.Request, ops []batchOperation) error {
+	if s.roles == nil {
+		return nil
+	}
+	id, _ := identityOf(r)
+	for i, op := range ops {
+		route := batchRoutes[op.Op]
+		if err := s.roles.authorize(id, route[0], route[1]); err != nil {
+			return fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// runBatchOperation runs one operation against s.store, which is the
+// batch's transaction.
+func (s *server) runBatchOperation(r *http.Request, op batchOperation) batchResult {
+	fail := func(status int, code, message string) batchResult {
+		return batchResult{Status: status, Error: &apiError{Code: code, Message: message}}
+	}
+	notFound := fail(http.StatusNotFound, codeNotFound, "movie not found")
+	preconditionFailed := fail(http.StatusPreconditionFailed, codePreconditionFailed, "the movie has been modified")
+	unknownDirector := batchResult{Status: http.StatusUnprocessableEntity, Error: &apiError{
+		Code:    codeValidation,
+		Message: "request body has invalid fields",
+		Details: []fieldError{{Field: "movie.directorId", Message: "no director with this ID"}},
+	}}
+
+	if op.Op == batchCreate {
+		created, err := s.create(*op.Movie)
+		if errors.Is(err, ErrMovieExists) {
+			return fail(http.StatusConflict, codeConflict, "a movie with id "+strconv.Quote(op.Movie.ID)+" already exists")
+		}
+		if errors.Is(err, ErrDirectorNotFound) {
+			return unknownDirector
+		}
+		if err != nil {
+			return batchServerError(r, err)
+		}
+		if err := s.record(r, MovieEvent{Action: actionCreate, Movie: created}); err != nil {
+			return batchServerError(r, err)
+		}
+		return batchResult{Status: http.StatusCreated, Movie: &created}
+	}
+
+	version, err := s.matchVersion(op.ID, op.IfMatch)
+	if errors.Is(err, ErrMovieNotFound) {
+		return notFound
+	}
+	if errors.Is(err, ErrVersionMismatch) {
+		return preconditionFailed
+	}
+	if err != nil {
+		return batchServerError(r, err)
+	}
+
+	if op.Op == batchDelete {
+		err := s.store.Delete(op.ID, version)
+		if errors.Is(err, ErrMovieNotFound) {
+			return notFound
+		}
+		if err != nil {
+			return batchServerError(r, err)
+		}
+		deleted, err := s.store.Get(op.ID)
+		if err == nil {
+			err = s.record(r, MovieEvent{Action: actionDelete, Movie: deleted})
+		}
+		if err != nil {
+			return batchServerError(r, err)
+		}
+		return batchResult{Status: http.StatusNoContent}
+	}
+
+	movie := *op.Movie
+	movie.Version = version
+	updated, err := s.update(op.ID, movie)
+	if errors.Is(err, ErrMovieNotFound) {
+		return notFound
+	}
+	if errors.Is(err, ErrDirectorNotFound) {
+		return unknownDirector
+	}
+	if err != nil {
+		return batchServerError(r, err)
+	}
+	if err := s.record(r, MovieEvent{Action: actionUpdate, Movie: updated}); err != nil {
+		return batchServerError(r, err)
+	}
+	return batchResult{Status: http.StatusOK, Movie: &updated}
+}
+
+// batchServerError logs an unexpected error like serverError does, and
+// fails the operation with a 500.
+func batchServerError(r *http.Request, err error) batchResult {
+	slog.ErrorContext(r.Context(), "internal error", "err", err)
+	return batchResult{Status: http.StatusInternalServerError, Error: &apiError{Code: codeInternal, Message: "internal server error"}}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// batch posts the operations and returns the response's status and body.
+func batch(t *testing.T, h http.Handler, ops string, header ...string) (int, batchResponse) {
+	t.Helper()
+	resp := do(t, h, "POST", "/movies:batch", `{"operations":[`+ops+`]}`, header...)
+	var body batchResponse
+	decodeBody(t, resp, &body)
+	return resp.StatusCode, body
+}
+
+func TestBatch(t *testing.T) {
+	h := newTestServer(t).routes()
+	etag := do(t, h, "GET", "/movies/1", "").Header.Get("ETag")
+
+	status, body := batch(t, h, `
+		{"op":"create","movie":{"id":"20","isbn":"9780345341464","title":"Heat","directorId":"1"}},
+		{"op":"update","id":"1","ifMatch":`+strconv.Quote(etag)+`,"movie":{"isbn":"9780345341464","title":"A New Hope","directorId":"1"}},
+		{"op":"delete","id":"2"}`)
+	if status != http.StatusOK || !body.Committed || len(body.Results) != 3 {
+		t.Fatalf("batch: status %d, %+v; want 200 and committed", status, body)
+	}
+	for i, want := range []int{http.StatusCreated, http.StatusOK, http.StatusNoContent} {
+		if got := body.Results[i]; got.Index != i || got.Status != want || got.Error != nil {
+			t.Errorf("result %d = %+v, want status %d", i, got, want)
+		}
+	}
+	var events []MovieEvent
+	decodeBody(t, do(t, h, "GET", "/movies/1/history", ""), &events)
+	if len(events) != 2 || events[1].Movie.Title != "A New Hope" {
+		t.Errorf("history of movie 1 = %+v, want the update recorded", events)
+	}
+}
+
+// One failed operation rolls back the others, which are reported as not
+// applied.
+func TestBatchRollback(t *testing.T) {
+	srv := newTestServer(t)
+	h := srv.routes()
+	stale := do(t, h, "GET", "/movies/3", "").Header.Get("ETag")
+	if resp := do(t, h, "PATCH", "/movies/3", `{"runtime":150}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("PATCH: status %d", resp.StatusCode)
+	}
+
+	for _, tt := range []struct {
+		name, failing string
+		status        int
+		code          string
+	}{
+		{"missing movie", `{"op":"delete","id":"99"}`, http.StatusNotFound, codeNotFound},
+		{"stale ifMatch", `{"op":"delete","id":"3","ifMatch":` + strconv.Quote(stale) + `}`, http.StatusPreconditionFailed, codePreconditionFailed},
+		{"taken ID", `{"op":"create","movie":{"id":"4","isbn":"9780345341464","title":"Heat","directorId":"1"}}`, http.StatusConflict, codeConflict},
+	} {
+		status, body := batch(t, h, `
+			{"op":"create","movie":{"id":"20","isbn":"9780345341464","title":"Heat","directorId":"1"}},
+			{"op":"update","id":"1","movie":{"isbn":"9780345341464","title":"A New Hope","directorId":"1"}},
+			`+tt.failing+`,
+			{"op":"delete","id":"2"}`)
+		if status != tt.status || body.Committed || body.Error == nil || body.Error.Code != tt.code {
+			t.Errorf("%s: status %d, %+v; want %d %s", tt.name, status, body.Error, tt.status, tt.code)
+			continue
+		}
+		if !strings.HasPrefix(body.Error.Message, "operation 2 failed") {
+			t.Errorf("%s: error message %q doesn't name operation 2", tt.name, body.Error.Message)
+		}
+		for i, result := range body.Results {
+			want, code := http.StatusFailedDependency, codeFailedDependency
+			if i == 2 {
+				want, code = tt.status, tt.code
+			}
+			if result.Index != i || result.Status != want || result.Error == nil || result.Error.Code != code {
+				t.Errorf("%s: result %d = %+v, want %d %s", tt.name, i, result, want, code)
+			}
+		}
+	}
+
+	// nothing was applied, or recorded
+	if _, err := srv.store.Get("20"); err == nil {
+		t.Error("movie 20 of a rolled back batch was stored")
+	}
+	for _, id := range []string{"1", "2"} {
+		movie, err := srv.store.Get(id)
+		if err != nil || movie.Version != 1 {
+			t.Errorf("movie %s after rolled back batches = %+v, %v; want it untouched", id, movie, err)
+		}
+		if n := historyLen(t, srv.store, id); n != 1 {
+			t.Errorf("movie %s has %d events, want only its baseline", id, n)
+		}
+	}
+}
+
+// A batch needs the permission of each of its operations.
+func TestBatchRoles(t *testing.T) {
+	dir := t.TempDir()
+	keys := writeFile(t, dir, "keys", "alice "+testAPIKey+"\nbob "+testAPIKey+"bob\n")
+	policy, err := authConfig{APIKeysFile: keys}.policy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestServer(t)
+	srv.auth = policy
+	srv.roles, err = loadRoles(writeFile(t, dir, "roles.json", `{
+		"roles": {
+			"editor": ["POST /movies:batch", "POST /movies", "PUT /movies/{id}"],
+			"admin": ["* *"]
+		},
+		"users": {"alice": "admin", "bob": "editor"}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := srv.routes()
+
+	create := `{"op":"create","movie":{"isbn":"9780345341464","title":"Heat","directorId":"1"}}`
+	status, body := batch(t, h, create+`,{"op":"delete","id":"2"}`, "X-API-Key", testAPIKey+"bob")
+	if status != http.StatusForbidden || body.Error == nil || !strings.Contains(body.Error.Message, "operation 1") {
+		t.Errorf("bob's batch with a delete: status %d, %+v; want 403 for operation 1", status, body.Error)
+	}
+	if movies, _ := srv.store.List(); len(movies) != len(seed.Movies) {
+		t.Errorf("a forbidden batch left %d movies, want %d", len(movies), len(seed.Movies))
+	}
+	if status, _ := batch(t, h, create, "X-API-Key", testAPIKey+"bob"); status != http.StatusOK {
+		t.Errorf("bob's batch with a create: status %d, want 200", status)
+	}
+	if status, _ := batch(t, h, create+`,{"op":"delete","id":"2"}`, "X-API-Key", testAPIKey); status != http.StatusOK {
+		t.Errorf("alice's batch with a delete: status %d, want 200", status)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func (s *server) getDirectors(w http.ResponseWriter, r *http.Request) {
+	directors, err := s.store.ListDirectors()
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, directors)
+}
+
+func (s *server) getDirector(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	director, err := s.store.GetDirector(params["id"])
+	if errors.Is(err, ErrDirectorNotFound) {
+		writeError(w, http.StatusNotFound, codeNotFound, "director not found")
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, director)
+}
+
+// getDirectorMovies lists the movies of one director. It takes the same
+// paging, sorting and filtering parameters as GET /movies.
+func (s *server) getDirectorMovies(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	if _, err := s.store.GetDirector(params["id"]); errors.Is(err, ErrDirectorNotFound) {
+		writeError(w, http.StatusNotFound, codeNotFound, "director not found")
+		return
+	} else if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	q, err := parseListQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	q.filter = append(q.filter, filter{field: "directorId", op: opEqual, value: params["id"]})
+
+	movies, err := s.store.List()
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	p := q.apply(movies)
+	setPageHeaders(w, r, q, p)
+	writeJSON(w, http.StatusOK, p.movies)
+}
+
+func (s *server) createDirector(w http.ResponseWriter, r *http.Request) {
+	var director Director
+	unknown, ok := decodeJSON(w, r, &director)
+	if !ok {
+		return
+	}
+	if errs := append(unknown, director.validate("")...); errs != nil {
+		writeValidationError(w, errs)
+		return
+	}
+
+	created, err := s.createDirectorWithID(director)
+	if errors.Is(err, ErrDirectorExists) {
+		writeError(w, http.StatusConflict, codeConflict, "a director with id "+strconv.Quote(director.ID)+" already exists")
+		return
+	}
+	if errors.Is(err, ErrDirectorNameTaken) {
+		writeError(w, http.StatusConflict, codeConflict, err.Error())
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/directors/"+url.PathEscape(created.ID))
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// createDirectorWithID stores the director, generating an ID for it unless
+// the client sent one. Works like create does for movies.
+func (s *server) createDirectorWithID(director Director) (Director, error) {
+	if director.ID != "" {
+		return s.store.CreateDirector(director)
+	}
+	for i := 0; i < maxIDAttempts; i++ {
+		director.ID = s.ids.NewID()
+		created, err := s.store.CreateDirector(director)
+		if !errors.Is(err, ErrDirectorExists) {
+			return created, err
+		}
+	}
+	return Director{}, fmt.Errorf("no free ID after %d attempts", maxIDAttempts)
+}
+
+func (s *server) updateDirector(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	var director Director
+	unknown, ok := decodeJSON(w, r, &director)
+	if !ok {
+		return
+	}
+	if errs := append(unknown, director.validate("")...); errs != nil {
+		writeValidationError(w, errs)
+		return
+	}
+
+	director, err := s.store.UpdateDirector(params["id"], director)
+	if errors.Is(err, ErrDirectorNotFound) {
+		writeError(w, http.StatusNotFound, codeNotFound, "director not found")
+		return
+	}
+	if errors.Is(err, ErrDirectorNameTaken) {
+		writeError(w, http.StatusConflict, codeConflict, err.Error())
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, director)
+}
+
+// deleteDirector refuses to delete a director who still has movies, unless
+// the request has ?cascade=true, in which case the movies are deleted too,
+// as DELETE /movies/{id} would, and their deletion recorded in their history.
+func (s *server) deleteDirector(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	cascade, err := queryBool(r, "cascade")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+
+	err = s.store.Atomically(func(store Store) error {
+		tx := s.in(store)
+		movies, err := moviesOf(store, params["id"])
+		if err != nil {
+			return err
+		}
+		if err := store.DeleteDirector(params["id"], cascade); err != nil {
+			return err
+		}
+		for _, movie := range movies {
+			deleted, err := store.Get(movie.ID)
+			if err != nil {
+				return err
+			}
+			if err := tx.record(r, MovieEvent{Action: actionDelete, Movie: deleted}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, ErrDirectorNotFound) {
+		writeError(w, http.StatusNotFound, codeNotFound, "director not found")
+		return
+	}
+	if errors.Is(err, ErrDirectorInUse) {
+		writeError(w, http.StatusConflict, codeConflict,
+			"director still has movies; delete them first or use ?cascade=true")
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// moviesOf returns the director's movies that aren't deleted.
+func moviesOf(store MovieStore, directorID string) ([]Movie, error) {
+	all, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	var movies []Movie
+	for _, movie := range all {
+		if movie.DirectorID == directorID && movie.DeletedAt == nil {
+			movies = append(movies, movie)
+		}
+	}
+	return movies, nil
+}
+
+// writeUnknownDirector reports a movie pointing to a director that doesn't
+// exist.
+func writeUnknownDirector(w http.ResponseWriter) {
+	writeValidationError(w, []fieldError{{Field: "directorId", Message: "no director with this ID"}})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDirectorConflicts(t *testing.T) {
+	h := newTestServer(t).routes()
+	for _, tt := range []struct {
+		method, target, body string
+		want                 int
+	}{
+		{"POST", "/directors", `{"id":"5","firstName":"Denis","lastName":"Villeneuve"}`, http.StatusCreated},
+		{"POST", "/directors", `{"id":"1","firstName":"Someone","lastName":"Else"}`, http.StatusConflict},
+		{"POST", "/directors", `{"firstName":"George","lastName":"Lucas"}`, http.StatusConflict},
+		{"PUT", "/directors/5", `{"firstName":"Peter","lastName":"Jackson"}`, http.StatusConflict},
+		{"PUT", "/directors/5", `{"firstName":"Denis","lastName":"Villeneuve"}`, http.StatusOK}, // its own name
+		{"PUT", "/directors/99", `{"firstName":"No","lastName":"One"}`, http.StatusNotFound},
+		{"GET", "/directors/99", "", http.StatusNotFound},
+		{"GET", "/directors/99/movies", "", http.StatusNotFound},
+		{"DELETE", "/directors/99", "", http.StatusNotFound},
+		{"DELETE", "/directors/5?cascade=maybe", "", http.StatusBadRequest},
+		{"DELETE", "/directors/5", "", http.StatusNoContent},
+		{"GET", "/directors/5", "", http.StatusNotFound},
+	} {
+		resp := do(t, h, tt.method, tt.target, tt.body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.target, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+// A director with movies is only deleted with ?cascade=true, and then the
+// movies' histories record that they went too.
+func TestDeleteDirectorCascade(t *testing.T) {
+	h := newTestServer(t).routes()
+	resp := do(t, h, "POST", "/movies", `{"id":"5","isbn":"9780618640157","title":"King Kong","directorId":"2"}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /movies: status %d", resp.StatusCode)
+	}
+
+	resp = do(t, h, "DELETE", "/directors/2", "")
+	var body errorResponse
+	decodeBody(t, resp, &body)
+	if resp.StatusCode != http.StatusConflict || body.Error.Code != codeConflict {
+		t.Errorf("DELETE without cascade: status %d, code %q; want 409 %s", resp.StatusCode, body.Error.Code, codeConflict)
+	}
+	if resp := do(t, h, "GET", "/movies/2", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /movies/2 after a refused delete: status %d, want 200", resp.StatusCode)
+	}
+
+	resp = do(t, h, "DELETE", "/directors/2?cascade=true", "", "X-Actor", "alice")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE with cascade: status %d, want 204", resp.StatusCode)
+	}
+	for _, id := range []string{"2", "5"} {
+		if resp := do(t, h, "GET", "/movies/"+id, ""); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET /movies/%s after the cascade: status %d, want 404", id, resp.StatusCode)
+		}
+		var events []MovieEvent
+		decodeBody(t, do(t, h, "GET", "/movies/"+id+"/history", ""), &events)
+		last := events[len(events)-1]
+		if last.Action != actionDelete || last.Actor != "alice" || last.Revision != 2 || last.Movie.DeletedAt == nil {
+			t.Errorf("movie %s's last event = %+v, want its deletion by alice", id, last)
+		}
+	}
+	if resp := do(t, h, "GET", "/movies/1", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /movies/1, another director's movie: status %d, want 200", resp.StatusCode)
+	}
+}
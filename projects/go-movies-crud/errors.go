@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// Error codes sent in the "code" field of an error response. Clients can
+// switch on these instead of parsing the message.
+const (
+	codeBadRequest           = "bad_request"
+	codeUnauthorized         = "unauthorized"
+	codeForbidden            = "forbidden"
+	codeNotFound             = "not_found"
+	codeMethodNotAllowed     = "method_not_allowed"
+	codeConflict             = "conflict"
+	codePreconditionFailed   = "precondition_failed"
+	codeFailedDependency     = "failed_dependency"
+	codeTooLarge             = "body_too_large"
+	codeUnsupportedMediaType = "unsupported_media_type"
+	codeTooManyRequests      = "too_many_requests"
+	codeValidation           = "validation_failed"
+	codeInternal             = "internal_error"
+)
+
+// apiError is the body of every error response:
+//
+//	{"error": {"code": "not_found", "message": "movie not found", "requestId": "..."}}
+type apiError struct {
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	Details   []fieldError `json:"details,omitempty"`
+	RequestID string       `json:"requestId,omitempty"` // to find the request in the logs
+}
+
+type errorResponse struct {
+	Error apiError `json:"error"`
+}
+
+// writeJSON sends v as JSON with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError sends an error response in the standard envelope.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeAPIError(w, status, apiError{Code: code, Message: message})
+}
+
+// writeAPIError sends e in the standard envelope, with the ID logRequests
+// gave the request.
+func writeAPIError(w http.ResponseWriter, status int, e apiError) {
+	e.RequestID = w.Header().Get(requestIDHeader)
+	writeJSON(w, status, errorResponse{Error: e})
+}
+
+// serverError logs err and sends a generic 500, so internal details
+// (file paths, SQL...) don't leak to clients.
+func serverError(w http.ResponseWriter, r *http.Request, err error) {
+	slog.ErrorContext(r.Context(), "internal error", "err", err)
+	writeError(w, http.StatusInternalServerError, codeInternal, "internal server error")
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, codeNotFound, "resource not found")
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method "+r.Method+" is not allowed here")
+}
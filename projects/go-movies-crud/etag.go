@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxConflictRetries is how many times PATCH and rating a movie start over
+// when someone else changed the movie in the meantime. Requests with an
+// If-Match header are never retried: they get a 412 instead, and the others
+// get a 409 once the retries run out.
+const maxConflictRetries = 3
+
+// movieETag returns the entity tag of a movie: its version followed by a
+// hash of its JSON representation. The version alone isn't enough, since
+// the embedded director changes without the movie's version going up, and
+// a purged ID can be created again with the same version. It is a strong
+// tag.
+func movieETag(movie Movie) string {
+	data, err := json.Marshal(movie)
+	if err != nil {
+		// can't happen for a Movie; fall back to a tag that never matches
+		return `"` + strconv.Itoa(movie.Version) + `-"`
+	}
+	sum := sha256.Sum256(data)
+	return `"` + strconv.Itoa(movie.Version) + "-" + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header matches
+// etag. The header is "*" or a comma separated list of tags. If-Match uses
+// strong comparison, where weak tags (W/"...") never match; If-None-Match
+// uses weak comparison, which ignores the W/ prefix.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = tag[2:]
+		}
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatch returns the request's If-Match header, or "" if there is none.
+func ifMatch(r *http.Request) string {
+	return strings.Join(r.Header.Values("If-Match"), ",")
+}
+
+// checkIfMatch reports whether the request's If-Match header, if any,
+// matches the current movie. Otherwise it sends a 412 and returns false.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, current Movie) bool {
+	if h := ifMatch(r); h != "" && !etagMatches(h, movieETag(current), false) {
+		writePreconditionFailed(w)
+		return false
+	}
+	return true
+}
+
+// expectedVersion is the version a PUT or DELETE must find in the store: the
+// current one if the request's If-Match matches it, or 0 (anything) if
+// there's no If-Match. It sends a 404 or 412 and returns false when the
+// request can't go on.
+func (s *server) expectedVersion(w http.ResponseWriter, r *http.Request, id string) (int, bool) {
+	version, err := s.matchVersion(id, ifMatch(r))
+	if errors.Is(err, ErrMovieNotFound) {
+		writeError(w, http.StatusNotFound, codeNotFound, "movie not found")
+		return 0, false
+	}
+	if errors.Is(err, ErrVersionMismatch) {
+		writePreconditionFailed(w)
+		return 0, false
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return 0, false
+	}
+	return version, true
+}
+
+// matchVersion checks an If-Match value against the movie and returns the
+// movie's version, or ErrVersionMismatch if it doesn't match. With no
+// If-Match it returns 0 without looking at the movie.
+func (s *server) matchVersion(id, ifMatch string) (int, error) {
+	if ifMatch == "" {
+		return 0, nil
+	}
+	current, err := s.getLive(id)
+	if err != nil {
+		return 0, err
+	}
+	if !etagMatches(ifMatch, movieETag(current), false) {
+		return 0, ErrVersionMismatch
+	}
+	return current.Version, nil
+}
+
+// writePreconditionFailed reports that the movie has changed since the
+// client last read it.
+func writePreconditionFailed(w http.ResponseWriter) {
+	writeError(w, http.StatusPreconditionFailed, codePreconditionFailed,
+		"the movie has been modified; fetch it again and retry")
+}
+
+// writeBusy reports that a request without If-Match kept losing the race
+// against other updates to the same movie.
+func writeBusy(w http.ResponseWriter) {
+	writeError(w, http.StatusConflict, codeConflict,
+		"the movie kept changing while being updated; try again")
+}
+
+// writeMovie sends a movie along with its ETag.
+func writeMovie(w http.ResponseWriter, status int, movie Movie) {
+	w.Header().Set("ETag", movieETag(movie))
+	writeJSON(w, status, movie)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// Renaming a director changes the movies that embed it, so their ETags must
+// change even though their versions don't.
+func TestETagFollowsDirector(t *testing.T) {
+	h := newTestServer(t).routes()
+
+	resp := do(t, h, "GET", "/movies/3", "")
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag on GET /movies/3")
+	}
+	if resp := do(t, h, "GET", "/movies/3", "", "If-None-Match", etag); resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("GET with current ETag: status %d, want 304", resp.StatusCode)
+	}
+
+	if resp := do(t, h, "PUT", "/directors/3", `{"firstName":"Chris","lastName":"Nolan"}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT /directors/3: status %d, want 200", resp.StatusCode)
+	}
+
+	resp = do(t, h, "GET", "/movies/3", "", "If-None-Match", etag)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET with stale ETag: status %d, want 200", resp.StatusCode)
+	}
+	if resp.Header.Get("ETag") == etag {
+		t.Errorf("ETag %s didn't change with the director", etag)
+	}
+	if resp := do(t, h, "DELETE", "/movies/3", "", "If-Match", etag); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("DELETE with stale If-Match: status %d, want 412", resp.StatusCode)
+	}
+	if resp := do(t, h, "DELETE", "/movies/3", "", "If-Match", resp.Header.Get("ETag")); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE with current If-Match: status %d, want 204", resp.StatusCode)
+	}
+}
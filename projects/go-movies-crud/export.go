@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csvColumns are the columns of a CSV export, in order. An import accepts
+// any subset of them, in any order.
+var csvColumns = []string{
+	"id", "isbn", "title", "directorId", "director.firstName", "director.lastName",
+	"releaseDate", "runtime", "genres", "cast", "ratings",
+}
+
+// In CSV, list fields hold their entries separated by listSeparator. A cast
+// entry is "actor:role" (or just "actor") and a rating is "user:score".
+// A separator or backslash inside a value is escaped with a backslash: the
+// role "Leia: Princess" is written "Leia\: Princess".
+const (
+	listSeparator  = '|'
+	entrySeparator = ':'
+)
+
+var csvEscaper = strings.NewReplacer(`\`, `\\`, "|", `\|`, ":", `\:`)
+
+// joinList escapes every entry and joins them with listSeparator.
+func joinList(entries []string) string {
+	escaped := make([]string, len(entries))
+	for i, entry := range entries {
+		escaped[i] = csvEscaper.Replace(entry)
+	}
+	return strings.Join(escaped, string(listSeparator))
+}
+
+// splitEscaped splits s at every sep that isn't escaped with a backslash.
+// The parts keep their escapes, for unescape.
+func splitEscaped(s string, sep byte) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++ // whatever comes next is part of the value
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unescape removes the backslashes joinList added.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// movieRecord turns a movie into a CSV row matching csvColumns.
+func movieRecord(m Movie) []string {
+	var firstName, lastName string
+	if m.Director != nil {
+		firstName, lastName = m.Director.FirstName, m.Director.LastName
+	}
+	runtime := ""
+	if m.Runtime > 0 {
+		runtime = strconv.Itoa(m.Runtime)
+	}
+	var cast, ratings []string
+	for _, c := range m.Cast {
+		if c.Role == "" {
+			cast = append(cast, csvEscaper.Replace(c.Actor))
+		} else {
+			cast = append(cast, csvEscaper.Replace(c.Actor)+string(entrySeparator)+csvEscaper.Replace(c.Role))
+		}
+	}
+	for _, rating := range m.Ratings {
+		ratings = append(ratings, csvEscaper.Replace(rating.User)+string(entrySeparator)+strconv.Itoa(rating.Score))
+	}
+	return []string{
+		m.ID, m.ISBN, m.Title, m.DirectorID, firstName, lastName,
+		m.ReleaseDate, runtime,
+		joinList(m.Genres),
+		strings.Join(cast, string(listSeparator)),
+		strings.Join(ratings, string(listSeparator)),
+	}
+}
+
+// exportMovies handles GET /movies:export?format=csv|ndjson (ndjson by
+// default). Movies are written one at a time as they are read from the
+// store, so the export never holds the whole catalog in memory. Deleted
+// movies are left out.
+func (s *server) exportMovies(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+
+	var write func(Movie) error
+	flush := func() error { return nil }
+	switch format {
+	case "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		write = func(m Movie) error { return enc.Encode(m) }
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+		cw.Write(csvColumns)
+		write = func(m Movie) error {
+			cw.Write(movieRecord(m))
+			return cw.Error()
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		writeError(w, http.StatusBadRequest, codeBadRequest, "format must be csv or ndjson")
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="movies.`+format+`"`)
+	// a big catalog can take longer to send than -write-timeout allows
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	err := s.store.Walk(func(m Movie) error {
+		if m.DeletedAt != nil {
+			return nil
+		}
+		return write(m)
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		// the status line is long gone: cut the response short so the
+		// client can tell the export is incomplete
+		slog.ErrorContext(r.Context(), "exporting movies", "err", err)
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Values with the CSV list separators in them survive an export followed
+// by an import.
+func TestCSVRoundTrip(t *testing.T) {
+	tricky := Movie{
+		ID: "9", ISBN: "9780345341464", Title: "Heat, the Director's Cut", DirectorID: "1",
+		Genres: []string{"Crime|Drama", `Back\slash`},
+		Cast: []CastMember{
+			{Actor: "Al Pacino", Role: "Lt. Vincent Hanna: LAPD"},
+			{Actor: "Robert De Niro"},
+			{Actor: "A|B", Role: `C\:D`},
+		},
+		Ratings: []Rating{{User: "al:ice", Score: 4}, {User: "bob|by", Score: 5}},
+	}
+
+	src := newTestServer(t).routes()
+	if resp := do(t, src, "POST", "/movies", mustJSON(t, tricky)); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST: status %d", resp.StatusCode)
+	}
+	resp := do(t, src, "GET", "/movies:export?format=csv", "")
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// import into an empty catalog with the same directors
+	store := newMemoryStore(catalog{Directors: seed.Directors})
+	index, err := newSearchIndex(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := newServer(indexedStore{Store: store, index: index}, uuidGenerator{}, index).routes()
+	req := do(t, dst, "POST", "/movies:import", string(body), "Content-Type", "text/csv")
+	var report struct {
+		Failed int `json:"failed"`
+	}
+	decodeBody(t, req, &report)
+	if report.Failed != 0 {
+		t.Fatalf("import failed %d rows of\n%s", report.Failed, body)
+	}
+
+	got, err := store.Get("9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Genres, tricky.Genres) || !reflect.DeepEqual(got.Cast, tricky.Cast) || !reflect.DeepEqual(got.Ratings, tricky.Ratings) {
+		t.Errorf("after the round trip:\ngot  %+v %+v %+v\nwant %+v %+v %+v",
+			got.Genres, got.Cast, got.Ratings, tricky.Genres, tricky.Cast, tricky.Ratings)
+	}
+}
+
+func mustJSON(t *testing.T, movie Movie) string {
+	t.Helper()
+	data, err := json.Marshal(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// Removing movies during a walk doesn't make it skip or repeat the others.
+func TestWalkWhileRemoving(t *testing.T) {
+	var c catalog
+	c.Directors = []Director{{ID: "d", FirstName: "A", LastName: "B"}}
+	for i := 0; i < 3*walkChunk+10; i++ {
+		c.Movies = append(c.Movies, Movie{ID: fmt.Sprint(i), DirectorID: "d"})
+	}
+	store := newMemoryStore(c)
+
+	var seen []string
+	err := store.Walk(func(movie Movie) error {
+		seen = append(seen, movie.ID)
+		if len(seen) == walkChunk+walkChunk/2 {
+			// purge movies that were handed out already, including the
+			// last ones of this chunk
+			for i := 0; i < 10; i++ {
+				store.Delete(fmt.Sprint(i), 0)
+			}
+			for i := 2*walkChunk - 5; i < 2*walkChunk; i++ {
+				store.Delete(fmt.Sprint(i), 0)
+			}
+			if _, err := store.Purge(time.Now().Add(time.Hour)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != len(c.Movies) {
+		t.Fatalf("walked %d movies, want %d", len(seen), len(c.Movies))
+	}
+	for i, id := range seen {
+		if id != fmt.Sprint(i) {
+			t.Fatalf("movie %d of the walk is %s, want %d", i, id, i)
+		}
+	}
+}
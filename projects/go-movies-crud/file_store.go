@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileStore keeps the movies in memory and saves the whole catalog to a
+// JSON file after every change.
+//
+// Writes go to a temporary file in the same directory which is then renamed
+// over the real one. A rename is atomic, so if the process dies half way
+// through a write the previous file is still there and still valid.
+//
+// The history would make every save slower as it grows, so it has a file of
+// its own with one event per line (NDJSON), which is only ever appended to.
+// The catalog records how many bytes of it are committed: events are
+// appended first and count once the catalog that goes with them has been
+// saved. Anything after that, left by a failed save or a crash, is cut off.
+type fileStore struct {
+	mu          sync.RWMutex
+	path        string
+	historyPath string
+	historySize int64 // committed bytes of the history file
+	mem         *memoryStore
+}
+
+// catalogFile is what the JSON file holds: the catalog without its history,
+// and how much of the history file goes with it. Files written before the
+// history had a file of its own have it inline instead.
+type catalogFile struct {
+	catalog
+	HistorySize int64 `json:"historySize"`
+}
+
+// newFileStore loads the catalog from path, and its history from the file
+// next to it. If path doesn't exist yet it is created with the seed catalog.
+func newFileStore(path string, seed catalog) (*fileStore, error) {
+	s := &fileStore{path: path, historyPath: historyPath(path)}
+
+	// remove temp files left behind by a crash in the middle of a write
+	leftovers, _ := filepath.Glob(s.tempPattern())
+	for _, name := range leftovers {
+		os.Remove(name)
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		// a history without its catalog is of no use: start afresh
+		s.mem = newMemoryStore(seed)
+		if err := s.commit(s.mem.eventsSince(0)); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := decodeCatalogFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	if len(f.History) > 0 {
+		// the history is still inline: move it to its own file
+		s.mem = newMemoryStore(f.catalog)
+		if err := s.commit(s.mem.eventsSince(0)); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+
+	f.History, err = readHistory(s.historyPath, f.HistorySize)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", s.historyPath, err)
+	}
+	s.historySize = f.HistorySize
+	s.mem = newMemoryStore(f.catalog)
+	return s, nil
+}
+
+// historyPath returns the name of the history file that goes with the
+// catalog at path: movies.json has movies.history.ndjson.
+func historyPath(path string) string {
+	return strings.TrimSuffix(path, ".json") + ".history.ndjson"
+}
+
+// readHistory decodes the first size bytes of the history file at path.
+// The file may be longer, if the process died before the catalog that goes
+// with the rest was saved; that part is ignored, and overwritten by the
+// next commit.
+func readHistory(path string, size int64) ([]MovieEvent, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) && size == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []MovieEvent
+	lines := bufio.NewScanner(io.LimitReader(file, size))
+	lines.Buffer(nil, 16<<20)
+	var read int64
+	for lines.Scan() {
+		read += int64(len(lines.Bytes())) + 1
+		var event MovieEvent
+		if err := json.Unmarshal(lines.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("event %d: %w", len(events)+1, err)
+		}
+		events = append(events, event)
+	}
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+	if read != size {
+		return nil, fmt.Errorf("history is %d bytes, the catalog expects %d", read, size)
+	}
+	return events, nil
+}
+
+// decodeCatalog decodes a catalog as the file store writes it. The history
+// is only there in files written before it had a file of its own.
+func decodeCatalog(data []byte) (catalog, error) {
+	f, err := decodeCatalogFile(data)
+	return f.catalog, err
+}
+
+func decodeCatalogFile(data []byte) (catalogFile, error) {
+	var f catalogFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		// files written before directors had their own IDs are a plain
+		// array of movies with the director embedded
+		var movies []Movie
+		if json.Unmarshal(data, &movies) != nil {
+			return catalogFile{}, err
+		}
+		f = catalogFile{catalog: legacyCatalog(movies)}
+	}
+	return f, nil
+}
+
+// legacyCatalog turns movies with embedded directors into a catalog, giving
+// every distinct director an ID of its own.
+func legacyCatalog(movies []Movie) catalog {
+	var c catalog
+	ids := map[Director]string{}
+	for _, movie := range movies {
+		if movie.Director != nil {
+			key := Director{FirstName: movie.Director.FirstName, LastName: movie.Director.LastName}
+			id, ok := ids[key]
+			if !ok {
+				id = strconv.Itoa(len(ids) + 1)
+				ids[key] = id
+				key.ID = id
+				c.Directors = append(c.Directors, key)
+			}
+			movie.DirectorID = id
+		}
+		c.Movies = append(c.Movies, movie)
+	}
+	return c
+}
+
+func (s *fileStore) ListDirectors() ([]Director, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.mem.ListDirectors()
+}
+
+func (s *fileStore) GetDirector(id string) (Director, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.mem.GetDirector(id)
+}
+
+func (s *fileStore) CreateDirector(director Director) (Director, error) {
+	var created Director
+	err := s.mutate(func(mem *memoryStore) (err error) {
+		created, err = mem.CreateDirector(director)
+		return err
+	})
+	return created, err
+}
+
+func (s *fileStore) UpdateDirector(id string, director Director) (Director, error) {
+	var updated Director
+	err := s.mutate(func(mem *memoryStore) (err error) {
+		updated, err = mem.UpdateDirector(id, director)
+		return err
+	})
+	return updated, err
+}
+
+func (s *fileStore) DeleteDirector(id string, cascade bool) error {
+	return s.mutate(func(mem *memoryStore) error {
+		return mem.DeleteDirector(id, cascade)
+	})
+}
+
+func (s *fileStore) List() ([]Movie, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.mem.List()
+}
+
+func (s *fileStore) Walk(fn func(Movie) error) error {
+	s.mu.RLock()
+	mem := s.mem
+	s.mu.RUnlock()
+	// mem has its own lock, and only holds it while copying a chunk
+	return mem.Walk(fn)
+}
+
+func (s *fileStore) Get(id string) (Movie, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.mem.Get(id)
+}
+
+func (s *fileStore) Create(movie Movie) (Movie, error) {
+	var created Movie
+	err := s.mutate(func(mem *memoryStore) (err error) {
+		created, err = mem.Create(movie)
+		return err
+	})
+	return created, err
+}
+
+func (s *fileStore) Update(id string, movie Movie) (Movie, error) {
+	var updated Movie
+	err := s.mutate(func(mem *memoryStore) (err error) {
+		updated, err = mem.Update(id, movie)
+		return err
+	})
+	return updated, err
+}
+
+func (s *fileStore) Delete(id string, version int) error {
+	return s.mutate(func(mem *memoryStore) error {
+		return mem.Delete(id, version)
+	})
+}
+
+func (s *fileStore) Restore(id string) (Movie, error) {
+	var restored Movie
+	err := s.mutate(func(mem *memoryStore) (err error) {
+		restored, err = mem.Restore(id)
+		return err
+	})
+	return restored, err
+}
+
+func (s *fileStore) Purge(deletedBefore time.Time) (int, error) {
+	// most runs find nothing to purge: don't rewrite the file for those
+	movies, err := s.List()
+	if err != nil {
+		return 0, err
+	}
+	found := false
+	for _, movie := range movies {
+		if movie.DeletedAt != nil && movie.DeletedAt.Before(deletedBefore) {
+			found = true
+		}
+	}
+	if !found {
+		return 0, nil
+	}
+
+	var n int
+	err = s.mutate(func(mem *memoryStore) (err error) {
+		n, err = mem.Purge(deletedBefore)
+		return err
+	})
+	return n, err
+}
+
+func (s *fileStore) AddEvent(event MovieEvent) error {
+	return s.mutate(func(mem *memoryStore) error {
+		return mem.AddEvent(event)
+	})
+}
+
+func (s *fileStore) History(movieID string) ([]MovieEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.mem.History(movieID)
+}
+
+// Atomically runs fn on the in-memory catalog and saves it once, after fn
+// succeeds.
+func (s *fileStore) Atomically(fn func(Store) error) error {
+	return s.mutate(func(mem *memoryStore) error {
+		return mem.Atomically(fn)
+	})
+}
+
+// Ping checks that a temp file can still be created next to the catalog,
+// which is what every save starts with.
+func (s *fileStore) Ping() error {
+	dir, base := filepath.Split(s.path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, base+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
+
+// Close waits for a write in progress to finish. Every change is saved as
+// it is made, so nothing else is left to flush.
+func (s *fileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return nil
+}
+
+// mutate applies fn and saves the result, along with the events fn added.
+// If the files can't be written the in-memory catalog is put back the way
+// it was, so memory and disk agree.
+func (s *fileStore) mutate(fn func(mem *memoryStore) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before := s.mem.snapshot()
+	if err := fn(s.mem); err != nil {
+		return err
+	}
+	if err := s.commit(s.mem.eventsSince(len(before.History))); err != nil {
+		s.mem = newMemoryStore(before)
+		return err
+	}
+	return nil
+}
+
+// commit appends events to the history file, then saves the catalog, which
+// makes them count. Callers must hold s.mu (or own s exclusively).
+func (s *fileStore) commit(events []MovieEvent) error {
+	size, err := s.appendHistory(events)
+	if err != nil {
+		return err
+	}
+	if err := s.save(size); err != nil {
+		return err
+	}
+	s.historySize = size
+	return nil
+}
+
+// appendHistory writes events to the history file right after its last
+// committed event, drops whatever came after that, and returns the new
+// size of the file.
+func (s *fileStore) appendHistory(events []MovieEvent) (int64, error) {
+	if len(events) == 0 {
+		return s.historySize, nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, event := range events {
+		if err := enc.Encode(event); err != nil {
+			return 0, err
+		}
+	}
+
+	file, err := os.OpenFile(s.historyPath, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	size := s.historySize + int64(buf.Len())
+	if _, err := file.WriteAt(buf.Bytes(), s.historySize); err != nil {
+		return 0, err
+	}
+	if err := file.Truncate(size); err != nil {
+		return 0, err
+	}
+	// the events must be on disk before the catalog that counts them
+	if err := file.Sync(); err != nil {
+		return 0, err
+	}
+	return size, file.Close()
+}
+
+// save writes the catalog, saying the history file has historySize bytes,
+// to a temp file, flushes it to disk and renames it over s.path. Callers
+// must hold s.mu (or own s exclusively).
+func (s *fileStore) save(historySize int64) error {
+	c := s.mem.snapshot()
+	c.History = nil
+	data, err := json.MarshalIndent(catalogFile{catalog: c, HistorySize: historySize}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir, base := filepath.Split(s.path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, base+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// if anything below fails, don't leave the temp file behind
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	// make sure the bytes are on disk before the rename makes them visible
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+
+	// flush the directory entry too, so the rename itself survives a crash
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+func (s *fileStore) tempPattern() string {
+	dir, base := filepath.Split(s.path)
+	return filepath.Join(dir, base+".tmp-*")
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openFileStore(t *testing.T, path string) *fileStore {
+	t.Helper()
+	s, err := newFileStore(path, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func historyLen(t *testing.T, s Store, id string) int {
+	t.Helper()
+	events, err := s.History(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(events)
+}
+
+// The history lives in a file of its own, next to the catalog, and survives
+// a restart.
+func TestFileStoreHistoryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "movies.json")
+	s := openFileStore(t, path)
+	if _, err := addBaselines(s); err != nil {
+		t.Fatal(err)
+	}
+	err := s.Atomically(func(tx Store) error {
+		movie, err := tx.Get("1")
+		if err != nil {
+			return err
+		}
+		movie.Title = "Star Wars: A New Hope"
+		if movie, err = tx.Update("1", movie); err != nil {
+			return err
+		}
+		return tx.AddEvent(MovieEvent{MovieID: "1", Revision: movie.Version, Action: actionUpdate, Movie: movie})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(`"history"`)) {
+		t.Errorf("catalog file has the history in it:\n%s", data)
+	}
+	lines, err := os.ReadFile(filepath.Join(filepath.Dir(path), "movies.history.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(lines, []byte("\n")); n != len(seed.Movies)+1 {
+		t.Errorf("history file has %d lines, want %d", n, len(seed.Movies)+1)
+	}
+
+	// a crash between writing an event and saving the catalog leaves an
+	// event that doesn't count
+	f, err := os.OpenFile(historyPath(path), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"movieId":"1","revision":9,"act`)
+	f.Close()
+
+	s = openFileStore(t, path)
+	if n := historyLen(t, s, "1"); n != 2 {
+		t.Fatalf("movie 1 has %d events after a restart, want 2", n)
+	}
+	if err := s.AddEvent(MovieEvent{MovieID: "1", Revision: 2, Action: actionUpdate}); err != nil {
+		t.Fatal(err)
+	}
+	s = openFileStore(t, path)
+	if n := historyLen(t, s, "1"); n != 3 {
+		t.Errorf("movie 1 has %d events after another restart, want 3", n)
+	}
+}
+
+// Catalog files written before the history had a file of its own have it
+// inline; it is moved out on the first start.
+func TestFileStoreMovesInlineHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "movies.json")
+	old := catalog{
+		Directors: seed.Directors,
+		Movies:    seed.Movies,
+		History:   []MovieEvent{{MovieID: "1", Revision: 1, Action: actionCreate, Movie: seed.Movies[0]}},
+	}
+	data, err := json.Marshal(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := openFileStore(t, path)
+	if n := historyLen(t, s, "1"); n != 1 {
+		t.Fatalf("movie 1 has %d events, want 1", n)
+	}
+	if data, _ := os.ReadFile(path); bytes.Contains(data, []byte(`"history"`)) {
+		t.Errorf("history is still inline:\n%s", data)
+	}
+	s = openFileStore(t, path)
+	if n := historyLen(t, s, "1"); n != 1 {
+		t.Errorf("movie 1 has %d events after a restart, want 1", n)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// probes serves the endpoints an orchestrator polls, ahead of the router so
+// they need no credentials and are never rate limited:
+//
+//	GET /healthz  200 while the process is up
+//	GET /readyz   200 while the server can take requests, 503 otherwise
+//	GET /version  the module version and VCS revision the binary was built from
+//
+// Every other request goes on to next.
+func (s *server) probes(next http.Handler) http.Handler {
+	handlers := map[string]http.HandlerFunc{
+		"/healthz": s.healthz,
+		"/readyz":  s.readyz,
+		"/version": s.version,
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler, ok := handlers[r.URL.Path]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+			info.route = r.URL.Path
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			methodNotAllowed(w, r)
+			return
+		}
+		handler(w, r)
+	})
+}
+
+func (s *server) healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readinessResponse is the body of /readyz. Checks are "ok" or say what is
+// wrong; the details of a failing store go to the log, not to the client.
+type readinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// readyz fails once a shutdown has started, so load balancers stop sending
+// requests, and while the store can't be written.
+func (s *server) readyz(w http.ResponseWriter, r *http.Request) {
+	resp := readinessResponse{Status: "ready", Checks: map[string]string{"server": "ok", "store": "ok"}}
+	if s.stopping.Load() {
+		resp.Checks["server"] = "shutting down"
+		resp.Status = "not ready"
+	}
+	if err := s.store.Ping(); err != nil {
+		slog.WarnContext(r.Context(), "store is not ready", "err", err)
+		resp.Checks["store"] = "failing"
+		resp.Status = "not ready"
+	}
+	status := http.StatusOK
+	if resp.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, resp)
+}
+
+// versionInfo is the body of /version.
+type versionInfo struct {
+	Module       string `json:"module"`
+	Version      string `json:"version"` // "(devel)" unless built with go install module@version
+	GoVersion    string `json:"goVersion"`
+	Revision     string `json:"revision,omitempty"`
+	RevisionTime string `json:"revisionTime,omitempty"`
+	Modified     bool   `json:"modified,omitempty"` // built with uncommitted changes
+}
+
+// buildVersion reads the version of the running binary from the build info
+// Go embeds in it. The VCS fields are missing when built outside a checkout
+// or with -buildvcs=false.
+func buildVersion() versionInfo {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return versionInfo{Module: "go-movies-crud", Version: "unknown"}
+	}
+	v := versionInfo{Module: bi.Main.Path, Version: bi.Main.Version, GoVersion: bi.GoVersion}
+	for _, setting := range bi.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			v.Revision = setting.Value
+		case "vcs.time":
+			v.RevisionTime = setting.Value
+		case "vcs.modified":
+			v.Modified = setting.Value == "true"
+		}
+	}
+	return v
+}
+
+func (s *server) version(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, buildVersion())
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// pingFailing is a store that can't be reached.
+type pingFailing struct {
+	Store
+}
+
+func (pingFailing) Ping() error {
+	return errors.New("database is locked")
+}
+
+func TestProbes(t *testing.T) {
+	srv := newTestServer(t)
+	h := srv.probes(srv.routes())
+
+	if resp := do(t, h, "GET", "/healthz", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /healthz: status %d, want 200", resp.StatusCode)
+	}
+	if resp := do(t, h, "POST", "/healthz", ""); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST /healthz: status %d, want 405", resp.StatusCode)
+	}
+	var version versionInfo
+	decodeBody(t, do(t, h, "GET", "/version", ""), &version)
+	if version.GoVersion == "" {
+		t.Errorf("/version has no Go version: %+v", version)
+	}
+
+	readyz := func(want int, checks map[string]string) {
+		t.Helper()
+		resp := do(t, h, "GET", "/readyz", "")
+		if resp.StatusCode != want {
+			t.Errorf("GET /readyz: status %d, want %d", resp.StatusCode, want)
+		}
+		var body readinessResponse
+		decodeBody(t, resp, &body)
+		for check, value := range checks {
+			if body.Checks[check] != value {
+				t.Errorf("readyz check %s = %q, want %q", check, body.Checks[check], value)
+			}
+		}
+	}
+	readyz(http.StatusOK, map[string]string{"server": "ok", "store": "ok"})
+
+	srv.store = pingFailing{srv.store}
+	readyz(http.StatusServiceUnavailable, map[string]string{"server": "ok", "store": "failing"})
+
+	srv.store = srv.store.(pingFailing).Store
+	// the probes need no credentials
+	keys := writeFile(t, t.TempDir(), "keys", "alice "+testAPIKey+"\n")
+	var err error
+	if srv.auth, err = (authConfig{APIKeysFile: keys}).policy(); err != nil {
+		t.Fatal(err)
+	}
+	h = srv.probes(srv.routes())
+	if resp := do(t, h, "GET", "/movies", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("GET /movies without a key: status %d, want 401", resp.StatusCode)
+	}
+	readyz(http.StatusOK, nil)
+
+	srv.stopping.Store(true)
+	readyz(http.StatusServiceUnavailable, map[string]string{"server": "shutting down", "store": "ok"})
+	// the liveness probe doesn't care
+	if resp := do(t, h, "GET", "/healthz", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /healthz while stopping: status %d, want 200", resp.StatusCode)
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// Actions recorded in a movie's history.
+const (
+	actionCreate  = "create"
+	actionUpdate  = "update"
+	actionDelete  = "delete"
+	actionRestore = "restore"
+	actionRevert  = "revert"
+)
+
+// MovieEvent is one change to a movie. Movie is the whole movie right after
+// the change, so every revision can be looked at and reverted to.
+type MovieEvent struct {
+	MovieID    string    `json:"movieId"`
+	Revision   int       `json:"revision"` // the movie's version after the change
+	Action     string    `json:"action"`
+	Actor      string    `json:"actor"`
+	At         time.Time `json:"at"`
+	Movie      Movie     `json:"movie"`
+	RevertedTo int       `json:"revertedTo,omitempty"`
+
+	// computed from the previous revision when the history is read;
+	// ignored on input
+	Changes []fieldChange `json:"changes,omitempty"`
+}
+
+// fieldChange is one field that a change added, removed or modified.
+type fieldChange struct {
+	Field  string `json:"field"`
+	Before any    `json:"before"`
+	After  any    `json:"after"`
+}
+
+func (e MovieEvent) clone() MovieEvent {
+	e.Movie = e.Movie.clone()
+	e.Changes = append([]fieldChange(nil), e.Changes...)
+	return e
+}
+
+// actor names whoever made the request: the identity it was authenticated
+// as or, when authentication is off, whatever the client sends in X-Actor.
+func actor(r *http.Request) string {
+	if id, ok := identityOf(r); ok {
+		return id.Name
+	}
+	name := strings.TrimSpace(r.Header.Get("X-Actor"))
+	if name == "" || utf8.RuneCountInString(name) > maxNameLength {
+		return "anonymous"
+	}
+	return name
+}
+
+// change runs fn, which changes a movie through the server it is given, and
+// adds the event fn returns to the movie's history in the same transaction:
+// the change and its event are either both stored or neither is. It returns
+// the changed movie, or fn's error as is.
+func (s *server) change(r *http.Request, fn func(tx *server) (MovieEvent, error)) (Movie, error) {
+	var event MovieEvent
+	err := s.store.Atomically(func(store Store) error {
+		tx := s.in(store)
+		var err error
+		if event, err = fn(tx); err != nil {
+			return err
+		}
+		return tx.record(r, event)
+	})
+	return event.Movie, err
+}
+
+// in returns a server that works on the transaction tx.
+func (s *server) in(tx Store) *server {
+	return &server{store: tx, ids: s.ids, index: s.index}
+}
+
+// record adds a change made by request r to the movie's history. Handlers
+// call it through change, or inside a transaction of their own, so the event
+// is stored together with the change.
+func (s *server) record(r *http.Request, event MovieEvent) error {
+	event.Movie = event.Movie.clone()
+	event.Movie.Director = nil
+	event.MovieID = event.Movie.ID
+	event.Revision = event.Movie.Version
+	event.Actor = actor(r)
+	event.At = time.Now().UTC().Truncate(time.Millisecond)
+	return s.store.AddEvent(event)
+}
+
+// baselineActor is the actor of the events addBaselines writes.
+const baselineActor = "system"
+
+// addBaselines gives every movie without a history a "create" event holding
+// the movie as it is now: the seed catalog, and movies stored before the
+// history was kept. Their current state becomes the first revision, which
+// later changes are compared with and which can be reverted to. It returns
+// how many events were added.
+func addBaselines(store Store) (int, error) {
+	movies, err := store.List()
+	if err != nil {
+		return 0, err
+	}
+	at := time.Now().UTC().Truncate(time.Millisecond)
+	var events []MovieEvent
+	for _, movie := range movies {
+		history, err := store.History(movie.ID)
+		if err != nil {
+			return 0, err
+		}
+		if len(history) > 0 {
+			continue
+		}
+		movie.Director = nil
+		events = append(events, MovieEvent{
+			MovieID:  movie.ID,
+			Revision: movie.Version,
+			Action:   actionCreate,
+			Actor:    baselineActor,
+			At:       at,
+			Movie:    movie,
+		})
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+	err = store.Atomically(func(tx Store) error {
+		for _, event := range events {
+			if err := tx.AddEvent(event); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
+
+// getHistory handles GET /movies/{id}/history: every recorded change to the
+// movie, oldest first, each with what it changed. The history of a deleted
+// or purged movie can still be read.
+func (s *server) getHistory(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	events, err := s.store.History(params["id"])
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	if len(events) == 0 {
+		if _, err := s.store.Get(params["id"]); errors.Is(err, ErrMovieNotFound) {
+			writeError(w, http.StatusNotFound, codeNotFound, "movie not found")
+			return
+		} else if err != nil {
+			serverError(w, r, err)
+			return
+		}
+	}
+
+	for i := range events {
+		var before *Movie
+		for j := i - 1; j >= 0; j-- {
+			if events[j].Revision == events[i].Revision-1 {
+				before = &events[j].Movie
+				break
+			}
+		}
+		// only a create is compared with nothing; another event whose
+		// previous revision is missing gets no changes rather than made
+		// up ones
+		if before != nil || events[i].Action == actionCreate {
+			events[i].Changes = diffMovies(before, events[i].Movie)
+		}
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+// diffMovies lists the fields that differ between two revisions of a movie,
+// by name. before is nil for a new movie. Fields derived from others, and
+// the version, are left out.
+func diffMovies(before *Movie, after Movie) []fieldChange {
+	was := map[string]any{}
+	if before != nil {
+		was = movieFieldsOf(*before)
+	}
+	is := movieFieldsOf(after)
+
+	names := map[string]bool{}
+	for name := range was {
+		names[name] = true
+	}
+	for name := range is {
+		names[name] = true
+	}
+	changes := []fieldChange{}
+	for name := range names {
+		if !reflect.DeepEqual(was[name], is[name]) {
+			changes = append(changes, fieldChange{Field: name, Before: was[name], After: is[name]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// movieFieldsOf returns the movie's JSON fields as a map.
+func movieFieldsOf(movie Movie) map[string]any {
+	movie.Director = nil
+	data, _ := json.Marshal(movie)
+	var fields map[string]any
+	json.Unmarshal(data, &fields)
+	for _, derived := range []string{"director", "version", "averageRating", "ratingCount"} {
+		delete(fields, derived)
+	}
+	return fields
+}
+
+// revertMovie handles POST /movies/{id}:revert, which puts a movie back the
+// way it was at an earlier revision. The revert is a change of its own, so
+// it gets a new revision and nothing in the history is lost.
+//
+//	{"revision": 2}
+func (s *server) revertMovie(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Revision int `json:"revision"`
+	}
+	unknown, ok := decodeJSON(w, r, &req)
+	if !ok {
+		return
+	}
+	if unknown != nil {
+		writeValidationError(w, unknown)
+		return
+	}
+
+	params := mux.Vars(r)
+	events, err := s.store.History(params["id"])
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	var target *MovieEvent
+	for i := range events {
+		if events[i].Revision == req.Revision {
+			target = &events[i] // the latest one, if the ID was reused
+		}
+	}
+	if target == nil {
+		writeValidationError(w, []fieldError{{Field: "revision", Message: "no such revision in the movie's history"}})
+		return
+	}
+
+	for attempt := 0; ; attempt++ {
+		current, err := s.getLive(params["id"])
+		if errors.Is(err, ErrMovieNotFound) {
+			writeError(w, http.StatusNotFound, codeNotFound, "movie not found")
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if !checkIfMatch(w, r, current) {
+			return
+		}
+
+		movie := target.Movie.clone()
+		movie.ID = current.ID
+		movie.Version = current.Version
+		movie.DeletedAt = nil
+		if errs := s.validateUpdate(current.ID, movie); errs != nil {
+			writeValidationError(w, errs)
+			return
+		}
+		movie, err = s.change(r, func(tx *server) (MovieEvent, error) {
+			reverted, err := tx.store.Update(current.ID, movie)
+			return MovieEvent{Action: actionRevert, Movie: reverted, RevertedTo: req.Revision}, err
+		})
+		if errors.Is(err, ErrVersionMismatch) {
+			if ifMatch(r) != "" {
+				writePreconditionFailed(w)
+				return
+			}
+			if attempt < maxConflictRetries {
+				continue
+			}
+			writeBusy(w)
+			return
+		}
+		if errors.Is(err, ErrMovieNotFound) {
+			writeError(w, http.StatusNotFound, codeNotFound, "movie not found")
+			return
+		}
+		if errors.Is(err, ErrDirectorNotFound) {
+			writeUnknownDirector(w)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		writeMovie(w, http.StatusOK, movie)
+		return
+	}
+}
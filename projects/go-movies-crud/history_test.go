@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// Seeded movies get a baseline event, so their first change has a diff and
+// their starting point can be reverted to.
+func TestHistoryBaseline(t *testing.T) {
+	h := newTestServer(t).routes()
+
+	var events []MovieEvent
+	decodeBody(t, do(t, h, "GET", "/movies/1/history", ""), &events)
+	if len(events) != 1 || events[0].Action != actionCreate || events[0].Actor != baselineActor || events[0].Revision != 1 {
+		t.Fatalf("history of a seeded movie = %+v, want one baseline create", events)
+	}
+
+	if resp := do(t, h, "PATCH", "/movies/1", `{"title":"Star Wars: A New Hope"}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("PATCH: status %d, want 200", resp.StatusCode)
+	}
+	if resp := do(t, h, "DELETE", "/movies/2", ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE: status %d, want 204", resp.StatusCode)
+	}
+	decodeBody(t, do(t, h, "GET", "/movies/2/history", ""), &events)
+	if len(events) != 2 || len(events[1].Changes) != 1 || events[1].Changes[0].Field != "deletedAt" {
+		t.Errorf("history after delete = %+v, want a delete that sets deletedAt", events)
+	}
+
+	resp := do(t, h, "POST", "/movies/1:revert", `{"revision":1}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("revert to the baseline: status %d, want 200", resp.StatusCode)
+	}
+	var movie Movie
+	decodeBody(t, resp, &movie)
+	if movie.Title != "Star Wars" || movie.Version != 3 {
+		t.Errorf("reverted movie = %q version %d, want \"Star Wars\" version 3", movie.Title, movie.Version)
+	}
+}
+
+// noHistory is a store that can't record events.
+type noHistory struct {
+	Store
+}
+
+var errNoHistory = errors.New("history is full")
+
+func (s noHistory) AddEvent(MovieEvent) error {
+	return errNoHistory
+}
+
+func (s noHistory) Atomically(fn func(Store) error) error {
+	return s.Store.Atomically(func(tx Store) error {
+		return fn(noHistory{tx})
+	})
+}
+
+// A change whose event can't be recorded isn't made at all.
+func TestChangeFailsWithoutHistory(t *testing.T) {
+	store := noHistory{newMemoryStore(seed)}
+	index, err := newSearchIndex(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newServer(indexedStore{Store: store, index: index}, uuidGenerator{}, index).routes()
+
+	requests := []struct{ method, target, body string }{
+		{"POST", "/movies", `{"isbn":"9780345341464","title":"Heat","directorId":"1"}`},
+		{"PUT", "/movies/1", `{"isbn":"9780345341464","title":"Heat","directorId":"1"}`},
+		{"PATCH", "/movies/1", `{"title":"Heat"}`},
+		{"POST", "/movies/1/ratings", `{"user":"alice","score":4}`},
+		{"DELETE", "/movies/1", ""},
+		{"POST", "/movies:batch", `{"operations":[{"op":"delete","id":"2"}]}`},
+	}
+	for _, req := range requests {
+		if resp := do(t, h, req.method, req.target, req.body); resp.StatusCode != http.StatusInternalServerError {
+			t.Errorf("%s %s: status %d, want 500", req.method, req.target, resp.StatusCode)
+		}
+	}
+
+	movies, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(movies) != len(seed.Movies) {
+		t.Errorf("%d movies, want the %d seeded ones", len(movies), len(seed.Movies))
+	}
+	for i, movie := range movies {
+		if movie.Version != 1 || movie.Title != seed.Movies[i].Title || movie.DeletedAt != nil || len(movie.Ratings) != len(seed.Movies[i].Ratings) {
+			t.Errorf("movie %s was changed: %+v", movie.ID, movie)
+		}
+	}
+}
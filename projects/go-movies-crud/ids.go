@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// IDGenerator hands out IDs for new movies. Generators only make collisions
+// unlikely; the store is what guarantees uniqueness, by refusing to create a
+// movie whose ID is already taken.
+type IDGenerator interface {
+	NewID() string
+}
+
+// newIDGenerator returns the generator registered under name: "uuid",
+// "ulid" or "seq". A sequence starts right after start.
+func newIDGenerator(name string, start uint64) (IDGenerator, error) {
+	switch name {
+	case "uuid":
+		return uuidGenerator{}, nil
+	case "ulid":
+		return &ulidGenerator{}, nil
+	case "seq":
+		return newSequenceGenerator(start), nil
+	}
+	return nil, fmt.Errorf("unknown ID generator %q (want uuid, ulid or seq)", name)
+}
+
+// uuidGenerator returns random (version 4) UUIDs such as
+// "f47ac10b-58cc-4372-a567-0e02b2c3d479".
+type uuidGenerator struct{}
+
+func (uuidGenerator) NewID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// ulidGenerator returns ULIDs: a 48 bit millisecond timestamp followed by
+// 80 random bits, written in Crockford base32. They sort by creation time.
+// Within the same millisecond the random part is incremented, so IDs from
+// one generator are strictly increasing.
+type ulidGenerator struct {
+	mu      sync.Mutex
+	lastMs  uint64
+	lastRnd [10]byte
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+func (g *ulidGenerator) NewID() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := uint64(time.Now().UnixMilli())
+	if ms <= g.lastMs {
+		// same (or earlier) millisecond: bump the random part by one
+		ms = g.lastMs
+		for i := len(g.lastRnd) - 1; i >= 0; i-- {
+			g.lastRnd[i]++
+			if g.lastRnd[i] != 0 {
+				break
+			}
+		}
+	} else if _, err := rand.Read(g.lastRnd[:]); err != nil {
+		panic(err)
+	}
+	g.lastMs = ms
+
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[0:8], ms<<16)
+	copy(b[6:], g.lastRnd[:])
+
+	// 128 bits -> 26 characters of 5 bits each (the first holds only 3)
+	hi := binary.BigEndian.Uint64(b[0:8])
+	lo := binary.BigEndian.Uint64(b[8:16])
+	out := make([]byte, 26)
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out)
+}
+
+// sequenceGenerator returns "1", "2", "3"... starting after a given number.
+type sequenceGenerator struct {
+	next atomic.Uint64
+}
+
+func newSequenceGenerator(start uint64) *sequenceGenerator {
+	g := &sequenceGenerator{}
+	g.next.Store(start)
+	return g
+}
+
+func (g *sequenceGenerator) NewID() string {
+	return strconv.FormatUint(g.next.Add(1), 10)
+}
+
+// maxNumericID returns the highest movie or director ID that is a plain
+// number, so a sequence can continue from there. The directors of deleted
+// movies count too, so a new director never takes the ID of a deleted one.
+func maxNumericID(movies []Movie, directors []Director) uint64 {
+	var highest uint64
+	check := func(id string) {
+		if n, err := strconv.ParseUint(id, 10, 64); err == nil && n > highest {
+			highest = n
+		}
+	}
+	for _, movie := range movies {
+		check(movie.ID)
+		check(movie.DirectorID)
+	}
+	for _, director := range directors {
+		check(director.ID)
+	}
+	return highest
+}
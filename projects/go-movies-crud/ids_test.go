@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestULIDsIncrease(t *testing.T) {
+	g := &ulidGenerator{}
+	last := ""
+	for i := 0; i < 1000; i++ {
+		id := g.NewID()
+		if len(id) != 26 || id <= last {
+			t.Fatalf("ID %d is %q after %q, want a greater 26 character ID", i, id, last)
+		}
+		last = id
+	}
+
+	// a clock that goes back keeps the last timestamp and bumps the
+	// random part
+	g.lastMs = uint64(time.Now().Add(time.Hour).UnixMilli())
+	a, b := g.NewID(), g.NewID()
+	if a[:10] != b[:10] || b <= a {
+		t.Errorf("IDs %q then %q, want the same timestamp and increasing", a, b)
+	}
+}
+
+// A sequence picks up after the highest numeric movie or director ID.
+func TestSequenceResumes(t *testing.T) {
+	movies := []Movie{{ID: "3"}, {ID: "f47ac10b-58cc-4372-a567-0e02b2c3d479"}, {ID: "12"}, {ID: "-5"}}
+	directors := []Director{{ID: "40"}, {ID: "x41"}}
+	start := maxNumericID(movies, directors)
+	if start != 40 {
+		t.Errorf("maxNumericID = %d, want 40", start)
+	}
+	g, err := newIDGenerator("seq", start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"41", "42"} {
+		if id := g.NewID(); id != want {
+			t.Errorf("NewID = %q, want %q", id, want)
+		}
+	}
+	if maxNumericID(nil, nil) != 0 {
+		t.Error("maxNumericID of nothing isn't 0")
+	}
+	if _, err := newIDGenerator("random", 0); err == nil {
+		t.Error("newIDGenerator accepted an unknown name")
+	}
+}
+
+// A generated ID that is taken is retried; a client supplied one is not.
+func TestCreateWithTakenID(t *testing.T) {
+	srv := newTestServer(t)
+	// the sequence starts on the sample movies' IDs
+	srv.ids = newSequenceGenerator(0)
+	h := srv.routes()
+
+	body := `{"isbn":"9780345341464","title":"New","directorId":"1"}`
+	resp := do(t, h, "POST", "/movies", body)
+	var created Movie
+	decodeBody(t, resp, &created)
+	if resp.StatusCode != http.StatusCreated || created.ID != "5" {
+		t.Errorf("POST /movies: status %d, id %q; want 201 with id 5", resp.StatusCode, created.ID)
+	}
+
+	resp = do(t, h, "POST", "/movies", `{"id":"2","isbn":"9780345341464","title":"New","directorId":"1"}`)
+	var conflict errorResponse
+	decodeBody(t, resp, &conflict)
+	if resp.StatusCode != http.StatusConflict || conflict.Error.Code != codeConflict {
+		t.Errorf("POST /movies with a taken id: status %d, code %q; want 409 %s", resp.StatusCode, conflict.Error.Code, codeConflict)
+	}
+	var movie Movie
+	decodeBody(t, do(t, h, "GET", "/movies/2", ""), &movie)
+	if movie.Title != "The Lord of the Rings" {
+		t.Errorf("movie 2 is now %q", movie.Title)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// maxImportBytes caps the size of an import, which holds many movies
+// instead of one.
+const maxImportBytes = 32 << 20
+
+// Statuses of an imported row.
+const (
+	importCreated = "created"
+	importValid   = "valid" // dry run: the row would have been created
+	importFailed  = "failed"
+)
+
+// importRow is one movie read from an import file, or why it couldn't be
+// read.
+type importRow struct {
+	movie Movie
+	err   error        // the row as a whole is unreadable
+	errs  []fieldError // some fields are unreadable
+}
+
+// importResult says what happened to one row of an import.
+type importResult struct {
+	Row    int          `json:"row"` // from 1, not counting a CSV header or blank lines
+	ID     string       `json:"id,omitempty"`
+	Status string       `json:"status"`
+	Error  string       `json:"error,omitempty"`
+	Errors []fieldError `json:"errors,omitempty"`
+}
+
+type importReport struct {
+	DryRun  bool           `json:"dryRun"`
+	Total   int            `json:"total"`
+	Created int            `json:"created"` // or would be, on a dry run
+	Failed  int            `json:"failed"`
+	Rows    []importResult `json:"rows"`
+}
+
+// importMovies handles POST /movies:import. The body is a CSV file (with a
+// header row, see csvColumns), a JSON array of movies or NDJSON, one movie
+// per line, depending on its Content-Type.
+//
+// Every row is checked and created on its own: valid rows are imported even
+// if others fail, and the response reports what happened to each. With
+// ?dryRun=true the rows are only checked and nothing is stored. A file that
+// can't be read at all (a broken CSV header, malformed JSON) is rejected
+// with a 400 before anything is stored.
+func (s *server) importMovies(w http.ResponseWriter, r *http.Request) {
+	dryRun, err := queryBool(r, "dryRun")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+
+	var read func(io.Reader) ([]importRow, error)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		read = readCSV
+	case "application/json":
+		read = readJSONArray
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		read = readNDJSON
+	default:
+		writeError(w, http.StatusUnsupportedMediaType, codeUnsupportedMediaType,
+			"import must be text/csv, application/json or application/x-ndjson")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	rows, err := read(r.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, codeTooLarge,
+			fmt.Sprintf("import must not be larger than %d bytes", tooLarge.Limit))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "invalid import: "+err.Error())
+		return
+	}
+
+	report := importReport{DryRun: dryRun, Total: len(rows), Rows: []importResult{}}
+	seen := map[string]bool{}
+	for i, row := range rows {
+		result := s.importRow(r, row, dryRun, seen)
+		result.Row = i + 1
+		if result.Status == importFailed {
+			report.Failed++
+		} else {
+			report.Created++
+		}
+		report.Rows = append(report.Rows, result)
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// importRow checks and, unless this is a dry run, creates one movie. seen
+// holds the IDs of the rows before it, which must not repeat.
+func (s *server) importRow(r *http.Request, row importRow, dryRun bool, seen map[string]bool) importResult {
+	failed := func(errs ...fieldError) importResult {
+		return importResult{ID: row.movie.ID, Status: importFailed, Errors: errs}
+	}
+	if row.err != nil {
+		return importResult{Status: importFailed, Error: row.err.Error()}
+	}
+	movie := row.movie
+	if errs := append(row.errs, movie.validate()...); errs != nil {
+		return failed(errs...)
+	}
+	if movie.ID != "" {
+		if seen[movie.ID] {
+			return failed(fieldError{Field: "id", Message: "appears more than once in the import"})
+		}
+		seen[movie.ID] = true
+	}
+
+	if dryRun {
+		return s.checkImport(movie)
+	}
+	created, err := s.change(r, func(tx *server) (MovieEvent, error) {
+		created, err := tx.create(movie)
+		return MovieEvent{Action: actionCreate, Movie: created}, err
+	})
+	if errors.Is(err, ErrMovieExists) {
+		return failed(fieldError{Field: "id", Message: "a movie with this ID already exists"})
+	}
+	if errors.Is(err, ErrDirectorNotFound) {
+		return failed(fieldError{Field: "directorId", Message: "no director with this ID"})
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "importing movie", "err", err)
+		return importResult{ID: movie.ID, Status: importFailed, Error: "internal server error"}
+	}
+	return importResult{ID: created.ID, Status: importCreated}
+}
+
+// checkImport does the checks the store would do on create, for a dry run.
+func (s *server) checkImport(movie Movie) importResult {
+	failed := func(field, message string) importResult {
+		return importResult{ID: movie.ID, Status: importFailed, Errors: []fieldError{{Field: field, Message: message}}}
+	}
+	if movie.ID != "" {
+		if _, err := s.store.Get(movie.ID); err == nil {
+			return failed("id", "a movie with this ID already exists")
+		}
+	}
+	directorID := movie.DirectorID
+	if directorID == "" && movie.Director != nil {
+		directorID = movie.Director.ID // a director given by name is created if needed
+	}
+	if directorID != "" {
+		if _, err := s.store.GetDirector(directorID); errors.Is(err, ErrDirectorNotFound) {
+			return failed("directorId", "no director with this ID")
+		}
+	}
+	return importResult{ID: movie.ID, Status: importValid}
+}
+
+// readCSV reads a CSV file whose first row names the columns.
+func readCSV(body io.Reader) ([]importRow, error) {
+	cr := csv.NewReader(body)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("the CSV file has no header row")
+	}
+	if err != nil {
+		return nil, err
+	}
+	known := map[string]bool{}
+	for _, name := range csvColumns {
+		known[name] = true
+	}
+	for i, name := range header {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown CSV column %q (columns are %s)", name, strings.Join(csvColumns, ", "))
+		}
+		for _, other := range header[:i] {
+			if other == name {
+				return nil, fmt.Errorf("CSV column %q appears twice", name)
+			}
+		}
+	}
+
+	var rows []importRow
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if errors.Is(err, csv.ErrFieldCount) {
+			rows = append(rows, importRow{err: fmt.Errorf("has %d fields, the header has %d", len(record), len(header))})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, movieFromRecord(header, record))
+	}
+}
+
+// movieFromRecord builds a movie from a CSV row. It is the reverse of
+// movieRecord.
+func movieFromRecord(header, record []string) importRow {
+	var row importRow
+	m := &row.movie
+	fail := func(field, message string) {
+		row.errs = append(row.errs, fieldError{Field: field, Message: message})
+	}
+	list := func(value string) []string {
+		if value == "" {
+			return nil
+		}
+		return splitEscaped(value, listSeparator)
+	}
+	// entry splits a cast or rating entry at its first separator
+	entry := func(value string) (string, string) {
+		parts := splitEscaped(value, entrySeparator)
+		if len(parts) == 1 {
+			return unescape(value), ""
+		}
+		return unescape(parts[0]), unescape(value[len(parts[0])+1:])
+	}
+
+	var director Director
+	for i, value := range record {
+		switch header[i] {
+		case "id":
+			m.ID = value
+		case "isbn":
+			m.ISBN = value
+		case "title":
+			m.Title = value
+		case "directorId":
+			m.DirectorID = value
+		case "director.firstName":
+			director.FirstName = value
+		case "director.lastName":
+			director.LastName = value
+		case "releaseDate":
+			m.ReleaseDate = value
+		case "runtime":
+			if value != "" {
+				n, err := strconv.Atoi(value)
+				if err != nil {
+					fail("runtime", "must be a whole number of minutes")
+				}
+				m.Runtime = n
+			}
+		case "genres":
+			for _, genre := range list(value) {
+				m.Genres = append(m.Genres, unescape(genre))
+			}
+		case "cast":
+			for _, item := range list(value) {
+				actor, role := entry(item)
+				m.Cast = append(m.Cast, CastMember{Actor: actor, Role: role})
+			}
+		case "ratings":
+			for _, item := range list(value) {
+				user, score := entry(item)
+				n, err := strconv.Atoi(score)
+				if err != nil {
+					fail("ratings", "entries must look like user"+string(entrySeparator)+"score")
+					continue
+				}
+				m.Ratings = append(m.Ratings, Rating{User: user, Score: n})
+			}
+		}
+	}
+	if director != (Director{}) {
+		m.Director = &director
+	}
+	return row
+}
+
+// readJSONArray reads a JSON array of movies. Elements that aren't valid
+// movies are reported per row; broken JSON fails the whole import.
+func readJSONArray(body io.Reader) ([]importRow, error) {
+	dec := json.NewDecoder(body)
+	if tok, err := dec.Token(); err != nil {
+		return nil, err
+	} else if tok != json.Delim('[') {
+		return nil, errors.New("expected a JSON array of movies")
+	}
+	var rows []importRow
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		rows = append(rows, decodeImportedMovie(raw))
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after the JSON array")
+	}
+	return rows, nil
+}
+
+// readNDJSON reads one movie per line. Blank lines are skipped; a line that
+// isn't a valid movie is reported as a failed row.
+func readNDJSON(body io.Reader) ([]importRow, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(nil, maxBodyBytes) // the same limit as a single movie
+	var rows []importRow
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		rows = append(rows, decodeImportedMovie(line))
+	}
+	return rows, scanner.Err()
+}
+
+// decodeImportedMovie decodes one movie as strictly as decodeJSON does:
+// unknown fields are reported with the row's other invalid fields.
+func decodeImportedMovie(data []byte) importRow {
+	var row importRow
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(&row.movie); err != nil {
+		return importRow{err: fmt.Errorf("invalid movie: %w", err)}
+	}
+	if dec.More() {
+		return importRow{err: errors.New("invalid movie: more than one JSON value")}
+	}
+	row.errs = unknownFields(data, reflect.TypeOf(row.movie))
+	return row
+}
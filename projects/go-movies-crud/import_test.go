@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// importRows are one of each kind of row, and what becomes of them.
+var importRows = []struct {
+	movie  string
+	status string
+	field  string // of the error, if any
+}{
+	{`{"id":"10","isbn":"9780345341464","title":"Heat","directorId":"1"}`, importCreated, ""},
+	{`{"id":"11","isbn":"9780345341464","directorId":"1"}`, importFailed, "title"},
+	{`{"id":"12","isbn":"9780345341464","title":"Heat","directorId":"1","colour":"red"}`, importFailed, "colour"},
+	{`{"id":"13","isbn":"9780345341464","title":"Heat","directorId":"99"}`, importFailed, "directorId"},
+	{`{"id":"10","isbn":"9780345341464","title":"Heat 2","directorId":"1"}`, importFailed, "id"}, // twice in the file
+	{`{"id":"1","isbn":"9780345341464","title":"Heat","directorId":"1"}`, importFailed, "id"},    // already stored
+	{`"not a movie"`, importFailed, ""},
+}
+
+func TestImport(t *testing.T) {
+	var lines []string
+	for _, row := range importRows {
+		lines = append(lines, row.movie)
+	}
+	bodies := map[string]string{
+		"application/json":     "[\n" + strings.Join(lines, ",\n") + "\n]",
+		"application/x-ndjson": strings.Join(lines, "\n") + "\n\n",
+	}
+
+	for contentType, body := range bodies {
+		for _, dryRun := range []bool{false, true} {
+			srv := newTestServer(t)
+			h := srv.routes()
+			target := "/movies:import"
+			if dryRun {
+				target += "?dryRun=true"
+			}
+			resp := do(t, h, "POST", target, body, "Content-Type", contentType)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("%s import: status %d, want 200", contentType, resp.StatusCode)
+			}
+			var report importReport
+			decodeBody(t, resp, &report)
+			if report.DryRun != dryRun || report.Total != len(importRows) || report.Created != 1 || report.Failed != len(importRows)-1 {
+				t.Errorf("%s import (dry run %v): report %+v", contentType, dryRun, report)
+				continue
+			}
+			for i, want := range importRows {
+				got := report.Rows[i]
+				status := want.status
+				if dryRun && status == importCreated {
+					status = importValid
+				}
+				if got.Row != i+1 || got.Status != status {
+					t.Errorf("%s row %d = %+v, want status %s", contentType, i+1, got, status)
+				}
+				if want.status == importFailed && want.field == "" && got.Error == "" {
+					t.Errorf("%s row %d has no error: %+v", contentType, i+1, got)
+				}
+				if want.field != "" && (len(got.Errors) == 0 || got.Errors[0].Field != want.field) {
+					t.Errorf("%s row %d errors = %+v, want one for %s", contentType, i+1, got.Errors, want.field)
+				}
+			}
+
+			_, err := srv.store.Get("10")
+			if stored := err == nil; stored == dryRun {
+				t.Errorf("%s import (dry run %v): movie 10 stored = %v", contentType, dryRun, stored)
+			}
+			if dryRun {
+				if movies, _ := srv.store.List(); len(movies) != len(seed.Movies) {
+					t.Errorf("dry run left %d movies, want %d", len(movies), len(seed.Movies))
+				}
+				if n := historyLen(t, srv.store, "10"); n != 0 {
+					t.Errorf("dry run recorded %d events", n)
+				}
+			}
+		}
+	}
+}
+
+// A file that can't be read at all stores nothing.
+func TestImportUnreadable(t *testing.T) {
+	srv := newTestServer(t)
+	h := srv.routes()
+	for _, tt := range []struct {
+		contentType, body string
+		want              int
+	}{
+		{"application/json", `[{"id":"10","isbn":"9780345341464","title":"Heat","directorId":"1"}, {"id":`, http.StatusBadRequest},
+		{"application/json", `{"id":"10","isbn":"9780345341464","title":"Heat","directorId":"1"}`, http.StatusBadRequest},
+		{"application/json", `[] []`, http.StatusBadRequest},
+		{"text/csv", "title,colour\nHeat,red\n", http.StatusBadRequest},
+		{"text/plain", "Heat", http.StatusUnsupportedMediaType},
+	} {
+		resp := do(t, h, "POST", "/movies:import", tt.body, "Content-Type", tt.contentType)
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s import of %q: status %d, want %d", tt.contentType, tt.body, resp.StatusCode, tt.want)
+		}
+	}
+	if _, err := srv.store.Get("10"); err == nil {
+		t.Error("movie 10 of a broken import was stored")
+	}
+}
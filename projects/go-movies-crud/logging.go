@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// requestIDHeader carries the ID of a request. A client can send its own,
+// to follow a request across services; otherwise the server makes one up.
+// Either way it is sent back, and appears in the logs and error responses.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the longest request ID taken from a client.
+const maxRequestIDLength = 128
+
+// newLogger returns a logger writing format ("text" or "json") to w, from
+// level on. Every record logged with the context of a request gets its
+// request ID.
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("log level %q must be debug, info, warn or error", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	var h slog.Handler
+	switch format {
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("log format %q must be text or json", format)
+	}
+	return slog.New(requestIDHandler{h}), nil
+}
+
+// requestIDHandler adds the request ID found in the context to records.
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		r.AddAttrs(slog.String("requestId", info.id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// requestInfo is what the access log needs to know about a request that
+// only the handlers further in find out.
+type requestInfo struct {
+	id    string
+	route string // the route template, once the router has matched one
+}
+
+type requestInfoKey struct{}
+
+// logRequests gives every request an ID and writes a line to the access log
+// once it is done. It wraps the whole router, so requests for unknown routes
+// are logged too.
+func logRequests(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{id: r.Header.Get(requestIDHeader)}
+		if !validRequestID(info.id) {
+			info.id = uuidGenerator{}.NewID()
+		}
+		w.Header().Set(requestIDHeader, info.id)
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+		rec := &responseRecorder{ResponseWriter: w}
+
+		defer func() {
+			// also log requests cut short by a panic, such as an export
+			// that failed half way
+			status := rec.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			panicked := recover()
+			if panicked != nil && rec.status == 0 {
+				status = http.StatusInternalServerError
+			}
+			level := slog.LevelInfo
+			if status >= 500 || panicked != nil {
+				level = slog.LevelError
+			}
+			attrs := []slog.Attr{
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", status),
+				slog.Duration("latency", time.Since(start)),
+				slog.Int64("bytes", rec.bytes),
+				slog.String("ip", clientIP(r)),
+			}
+			if info.route != "" {
+				attrs = append(attrs, slog.String("route", info.route))
+			}
+			if panicked != nil {
+				attrs = append(attrs, slog.Bool("aborted", true))
+			}
+			logger.LogAttrs(r.Context(), level, "request", attrs...)
+			if panicked != nil {
+				panic(panicked)
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// recordRoute notes the route the router matched, for logRequests. It is
+// the router's first middleware.
+func recordRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+			info.route, _ = mux.CurrentRoute(r).GetPathTemplate()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validRequestID accepts IDs of printable ASCII without spaces, so a client
+// can't forge log lines with one.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// responseRecorder remembers the status and size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the real writer, to flush it or
+// change its deadlines.
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newLoggedServer returns the routes of a test server inside logRequests,
+// and the buffer the JSON logs go to.
+func newLoggedServer(t *testing.T, next http.Handler) (http.Handler, *bytes.Buffer) {
+	t.Helper()
+	var logs bytes.Buffer
+	logger, err := newLogger(&logs, "json", "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next == nil {
+		next = newTestServer(t).routes()
+	}
+	return logRequests(logger, next), &logs
+}
+
+// logLines decodes the JSON log lines written so far.
+func logLines(t *testing.T, logs *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	dec := json.NewDecoder(logs)
+	for dec.More() {
+		var line map[string]any
+		if err := dec.Decode(&line); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// The request ID a client sends, or the one made up for it, is sent back
+// and appears in error responses and in every log line of the request.
+func TestRequestID(t *testing.T) {
+	h, logs := newLoggedServer(t, nil)
+
+	resp := do(t, h, "GET", "/movies/99", "", requestIDHeader, "trace-42")
+	if got := resp.Header.Get(requestIDHeader); got != "trace-42" {
+		t.Errorf("%s = %q, want trace-42", requestIDHeader, got)
+	}
+	var body errorResponse
+	decodeBody(t, resp, &body)
+	if body.Error.RequestID != "trace-42" {
+		t.Errorf("error requestId = %q, want trace-42", body.Error.RequestID)
+	}
+	if lines := logLines(t, logs); len(lines) != 1 || lines[0]["requestId"] != "trace-42" {
+		t.Errorf("log lines %v, want one with requestId trace-42", lines)
+	}
+
+	// IDs that could forge log lines, or none, are replaced by new ones
+	seen := map[string]bool{}
+	for _, id := range []string{"", "two words", "line\nbreak", strings.Repeat("x", maxRequestIDLength+1)} {
+		got := do(t, h, "GET", "/movies", "", requestIDHeader, id).Header.Get(requestIDHeader)
+		if got == id || !validRequestID(got) || seen[got] {
+			t.Errorf("request ID %q came back as %q", id, got)
+		}
+		seen[got] = true
+	}
+
+	// records a handler logs with the request's context get its ID too
+	h, logs = newLoggedServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger, _ := newLogger(logs, "json", "info")
+		logger.InfoContext(r.Context(), "working")
+	}))
+	do(t, h, "GET", "/", "", requestIDHeader, "trace-43")
+	lines := logLines(t, logs)
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want 2", len(lines))
+	}
+	for _, line := range lines {
+		if line["requestId"] != "trace-43" {
+			t.Errorf("%q line has requestId %v, want trace-43", line["msg"], line["requestId"])
+		}
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	h, logs := newLoggedServer(t, nil)
+	resp := do(t, h, "GET", "/movies/1", "")
+	size, _ := io.Copy(io.Discard, resp.Body)
+	do(t, h, "GET", "/nope", "")
+
+	lines := logLines(t, logs)
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want 2", len(lines))
+	}
+	line := lines[0]
+	want := map[string]any{
+		"level": "INFO", "msg": "request", "method": "GET", "path": "/movies/1",
+		"route": "/movies/{id}", "status": 200.0, "bytes": float64(size), "ip": "192.0.2.1",
+	}
+	for key, value := range want {
+		if line[key] != value {
+			t.Errorf("%s = %v, want %v", key, line[key], value)
+		}
+	}
+	if _, ok := line["latency"].(float64); !ok {
+		t.Errorf("latency = %v, want a number", line["latency"])
+	}
+	// a request no route matches has no route
+	if route, ok := lines[1]["route"]; ok || lines[1]["status"] != 404.0 {
+		t.Errorf("unmatched request logged with route %v and status %v", route, lines[1]["status"])
+	}
+
+	// a request cut short is logged as an error, and the panic goes on
+	h, logs = newLoggedServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("half"))
+		panic(http.ErrAbortHandler)
+	}))
+	func() {
+		defer func() {
+			if recover() != http.ErrAbortHandler {
+				t.Error("logRequests swallowed the panic")
+			}
+		}()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/movies:export", nil))
+	}()
+	lines = logLines(t, logs)
+	if len(lines) != 1 || lines[0]["level"] != "ERROR" || lines[0]["aborted"] != true || lines[0]["status"] != 200.0 {
+		t.Errorf("aborted request logged as %v", lines)
+	}
+}
@@ -1,107 +1,634 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
-	"math/rand"
+	"log/slog"
+	"math"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
+	"go-config"
 )
 
 type Movie struct {
-	ID       string    `json:"id"`
-	ISBN     string    `json:"isbn"`
-	Title    string    `json:"title"`
-	Director *Director `json:"director"`
+	ID          string       `json:"id"`
+	ISBN        string       `json:"isbn"`
+	Title       string       `json:"title"`
+	DirectorID  string       `json:"directorId"`
+	Director    *Director    `json:"director,omitempty"`
+	ReleaseDate string       `json:"releaseDate,omitempty"` // YYYY-MM-DD
+	Runtime     int          `json:"runtime,omitempty"`     // minutes
+	Genres      []string     `json:"genres,omitempty"`
+	Cast        []CastMember `json:"cast,omitempty"`
+	Ratings     []Rating     `json:"ratings,omitempty"`
+
+	// computed from Ratings when the movie is encoded; ignored on input
+	AverageRating float64 `json:"averageRating,omitempty"`
+	RatingCount   int     `json:"ratingCount"`
+
+	// set by the store and bumped on every update; the ETag starts with
+	// it. Ignored on input: clients send If-Match instead.
+	Version int `json:"version"`
+	// set by the store when the movie is deleted, until it is restored or
+	// purged. Ignored on input.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
 }
 
 type Director struct {
+	ID        string `json:"id"`
 	FirstName string `json:"firstName"`
 	LastName  string `json:"lastName"`
 }
 
-var movies []Movie
+type CastMember struct {
+	Actor string `json:"actor"`
+	Role  string `json:"role,omitempty"`
+}
+
+// Rating is one user's score for a movie, from 1 to 5.
+type Rating struct {
+	User  string `json:"user"`
+	Score int    `json:"score"`
+}
+
+// MarshalJSON fills in AverageRating and RatingCount, so they always match
+// Ratings.
+func (m Movie) MarshalJSON() ([]byte, error) {
+	type plain Movie // same fields, no MarshalJSON: avoids endless recursion
+	m.AverageRating, m.RatingCount = averageRating(m.Ratings)
+	return json.Marshal(plain(m))
+}
+
+// averageRating returns the mean score, rounded to two decimals, and the
+// number of ratings.
+func averageRating(ratings []Rating) (float64, int) {
+	if len(ratings) == 0 {
+		return 0, 0
+	}
+	sum := 0
+	for _, r := range ratings {
+		sum += r.Score
+	}
+	avg := float64(sum) / float64(len(ratings))
+	return math.Round(avg*100) / 100, len(ratings)
+}
+
+// clone returns a copy of the movie that shares no slices or pointers with
+// the original.
+func (m Movie) clone() Movie {
+	if m.Director != nil {
+		director := *m.Director
+		m.Director = &director
+	}
+	m.Genres = append([]string(nil), m.Genres...)
+	m.Cast = append([]CastMember(nil), m.Cast...)
+	m.Ratings = append([]Rating(nil), m.Ratings...)
+	if m.DeletedAt != nil {
+		deletedAt := *m.DeletedAt
+		m.DeletedAt = &deletedAt
+	}
+	return m
+}
+
+// server holds the dependencies shared by the HTTP handlers.
+type server struct {
+	store   Store
+	ids     IDGenerator
+	index   *searchIndex
+	auth    *authPolicy // nil when authentication is off
+	roles   *rolePolicy // nil when every authenticated user may do anything
+	limit   *rateLimiter
+	metrics *metrics // nil when -metrics is off
 
-func getMovies(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(movies)
+	stopping atomic.Bool // set once a shutdown starts, to fail /readyz
 }
 
-func deleteMovie(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+func newServer(store Store, ids IDGenerator, index *searchIndex) *server {
+	return &server{store: store, ids: ids, index: index}
+}
+
+// maxIDAttempts is how many generated IDs createMovie and createDirector
+// try before giving up. Any sane generator succeeds on the first one.
+const maxIDAttempts = 5
+
+func (s *server) getMovies(w http.ResponseWriter, r *http.Request) {
+	q, err := parseListQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	movies, err := s.store.List()
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	p := q.apply(movies)
+	setPageHeaders(w, r, q, p)
+	writeJSON(w, http.StatusOK, p.movies)
+}
+
+func (s *server) deleteMovie(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
-	for index, item := range movies {
-		if item.ID == params["id"] {
-			movies = append(movies[:index], movies[index+1:]...)
-			break
+	version, ok := s.expectedVersion(w, r, params["id"])
+	if !ok {
+		return
+	}
+	_, err := s.change(r, func(tx *server) (MovieEvent, error) {
+		if err := tx.store.Delete(params["id"], version); err != nil {
+			return MovieEvent{}, err
 		}
+		// deleted movies are kept, so the history has the deleted version
+		deleted, err := tx.store.Get(params["id"])
+		return MovieEvent{Action: actionDelete, Movie: deleted}, err
+	})
+	if errors.Is(err, ErrMovieNotFound) {
+		writeError(w, http.StatusNotFound, codeNotFound, "movie not found")
+		return
 	}
-	json.NewEncoder(w).Encode(movies)
+	if errors.Is(err, ErrVersionMismatch) {
+		writePreconditionFailed(w)
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func getMovie(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+// getMovie sends the movie with its ETag. A request whose If-None-Match
+// matches the ETag gets an empty 304 instead. Deleted movies are not found
+// unless the request has ?includeDeleted=true.
+func (s *server) getMovie(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
-	for _, item := range movies {
-		if item.ID == params["id"] {
-			json.NewEncoder(w).Encode(item)
-			return
-		}
+	includeDeleted, err := queryBool(r, "includeDeleted")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	movie, err := s.store.Get(params["id"])
+	if err == nil && movie.DeletedAt != nil && !includeDeleted {
+		err = ErrMovieNotFound
+	}
+	if errors.Is(err, ErrMovieNotFound) {
+		writeError(w, http.StatusNotFound, codeNotFound, "movie not found")
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	etag := movieETag(movie)
+	if h := r.Header.Get("If-None-Match"); h != "" && etagMatches(h, etag, true) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
 	}
-	json.NewEncoder(w).Encode(&Movie{})
+	writeMovie(w, http.StatusOK, movie)
 }
 
-func createMovie(w http.ResponseWriter, r *http.Request) {
-	// set json content type
-	w.Header().Set("Content-Type", "application/json")
+func (s *server) createMovie(w http.ResponseWriter, r *http.Request) {
 	// declare a movie variable
 	var movie Movie
-	_ = json.NewDecoder(r.Body).Decode(&movie)
-	// movie.ID = fmt.Sprintf("%d", len(movies)+1)
-	// generate a random ID for the movie
-	movie.ID = strconv.Itoa(rand.Intn(1000000))
-	movies = append(movies, movie)
-	json.NewEncoder(w).Encode(movie)
+	unknown, ok := decodeJSON(w, r, &movie)
+	if !ok {
+		return
+	}
+	if errs := append(unknown, movie.validate()...); errs != nil {
+		writeValidationError(w, errs)
+		return
+	}
+	created, err := s.change(r, func(tx *server) (MovieEvent, error) {
+		created, err := tx.create(movie)
+		return MovieEvent{Action: actionCreate, Movie: created}, err
+	})
+	if errors.Is(err, ErrMovieExists) {
+		writeError(w, http.StatusConflict, codeConflict, "a movie with id "+strconv.Quote(movie.ID)+" already exists")
+		return
+	}
+	if errors.Is(err, ErrDirectorNotFound) {
+		writeUnknownDirector(w)
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	movie = created
+	w.Header().Set("Location", "/movies/"+url.PathEscape(movie.ID))
+	writeMovie(w, http.StatusCreated, movie)
+}
+
+// create stores the movie. A client supplied ID is kept as is (and fails
+// with ErrMovieExists if taken); otherwise a new ID is generated, retrying
+// if the generator happens to return one that is already used.
+func (s *server) create(movie Movie) (Movie, error) {
+	movie, err := s.resolveDirector(movie)
+	if err != nil {
+		return Movie{}, err
+	}
+	if movie.ID != "" {
+		return s.store.Create(movie)
+	}
+	for i := 0; i < maxIDAttempts; i++ {
+		movie.ID = s.ids.NewID()
+		created, err := s.store.Create(movie)
+		if !errors.Is(err, ErrMovieExists) {
+			return created, err
+		}
+	}
+	return Movie{}, fmt.Errorf("no free ID after %d attempts", maxIDAttempts)
+}
+
+// update replaces the movie with the given ID. If movie.Version is set, the
+// stored movie must still have that version.
+func (s *server) update(id string, movie Movie) (Movie, error) {
+	movie, err := s.resolveDirector(movie)
+	if err != nil {
+		return Movie{}, err
+	}
+	return s.store.Update(id, movie)
 }
 
-func updateMovie(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+// resolveDirector sets DirectorID for clients that send the director
+// embedded by name instead of by ID, as the API did before directors were a
+// resource of their own. The director is looked up by name and created if
+// it doesn't exist yet.
+func (s *server) resolveDirector(movie Movie) (Movie, error) {
+	if movie.DirectorID != "" || movie.Director == nil {
+		return movie, nil
+	}
+	if movie.Director.ID != "" {
+		movie.DirectorID = movie.Director.ID
+		return movie, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		directors, err := s.store.ListDirectors()
+		if err != nil {
+			return Movie{}, err
+		}
+		for _, d := range directors {
+			if d.FirstName == movie.Director.FirstName && d.LastName == movie.Director.LastName {
+				movie.DirectorID = d.ID
+				return movie, nil
+			}
+		}
+		created, err := s.createDirectorWithID(*movie.Director)
+		if errors.Is(err, ErrDirectorNameTaken) {
+			continue // created by someone else in the meantime: look again
+		}
+		if err != nil {
+			return Movie{}, err
+		}
+		movie.DirectorID = created.ID
+		return movie, nil
+	}
+	return Movie{}, ErrDirectorNameTaken
+}
+
+// updateMovie replaces a movie. With an If-Match header the update only
+// happens if the movie hasn't changed since the client read it.
+func (s *server) updateMovie(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
-	for index, item := range movies {
-		if item.ID == params["id"] {
-			// delete the existing movie
-			movies = append(movies[:index], movies[index+1:]...)
-			// create a new movie
-			var movie Movie
-			_ = json.NewDecoder(r.Body).Decode(&movie)
-			// assign the same ID
-			movie.ID = params["id"]
-			movies = append(movies, movie)
-			json.NewEncoder(w).Encode(movie)
-			return
+	var movie Movie
+	unknown, ok := decodeJSON(w, r, &movie)
+	if !ok {
+		return
+	}
+	if errs := append(unknown, s.validateUpdate(params["id"], movie)...); errs != nil {
+		writeValidationError(w, errs)
+		return
+	}
+	version, ok := s.expectedVersion(w, r, params["id"])
+	if !ok {
+		return
+	}
+	movie.Version = version
+	movie, err := s.change(r, func(tx *server) (MovieEvent, error) {
+		updated, err := tx.update(params["id"], movie)
+		return MovieEvent{Action: actionUpdate, Movie: updated}, err
+	})
+	if errors.Is(err, ErrMovieNotFound) {
+		writeError(w, http.StatusNotFound, codeNotFound, "movie not found")
+		return
+	}
+	if errors.Is(err, ErrVersionMismatch) {
+		writePreconditionFailed(w)
+		return
+	}
+	if errors.Is(err, ErrDirectorNotFound) {
+		writeUnknownDirector(w)
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	writeMovie(w, http.StatusOK, movie)
+}
+
+// routes registers every movie and director endpoint on a new router.
+func (s *server) routes() *mux.Router {
+	router := mux.NewRouter()
+	router.NotFoundHandler = http.HandlerFunc(notFound)
+	router.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowed)
+	router.Use(recordRoute)
+	// credentials are checked once, before rate limiting so that it can
+	// count requests per user, but refused only after it, so that bad
+	// credentials count too
+	if s.auth != nil {
+		router.Use(s.auth.identify)
+	}
+	if s.limit != nil {
+		router.Use(s.limit.middleware)
+	}
+	if s.auth != nil {
+		router.Use(s.auth.require)
+	}
+	if s.roles != nil {
+		router.Use(s.roles.middleware)
+	}
+
+	router.HandleFunc("/movies", s.getMovies).Methods("GET")
+	// must come before /movies/{id}, or "search" would be taken as an ID
+	router.HandleFunc("/movies/search", s.searchMovies).Methods("GET")
+	router.HandleFunc("/movies/{id}", s.getMovie).Methods("GET")
+	router.HandleFunc("/movies", s.createMovie).Methods("POST")
+	router.HandleFunc("/movies:import", s.importMovies).Methods("POST")
+	router.HandleFunc("/movies:export", s.exportMovies).Methods("GET")
+	router.HandleFunc("/movies:batch", s.batchMovies).Methods("POST")
+	router.HandleFunc("/movies/{id}", s.updateMovie).Methods("PUT")
+	router.HandleFunc("/movies/{id}", s.patchMovie).Methods("PATCH")
+	router.HandleFunc("/movies/{id}", s.deleteMovie).Methods("DELETE")
+	router.HandleFunc("/movies/{id}/ratings", s.rateMovie).Methods("POST")
+	router.HandleFunc("/movies/{id}:restore", s.restoreMovie).Methods("POST")
+	router.HandleFunc("/movies/{id}/history", s.getHistory).Methods("GET")
+	router.HandleFunc("/movies/{id}:revert", s.revertMovie).Methods("POST")
+
+	router.HandleFunc("/directors", s.getDirectors).Methods("GET")
+	router.HandleFunc("/directors/{id}", s.getDirector).Methods("GET")
+	router.HandleFunc("/directors/{id}/movies", s.getDirectorMovies).Methods("GET")
+	router.HandleFunc("/directors", s.createDirector).Methods("POST")
+	router.HandleFunc("/directors/{id}", s.updateDirector).Methods("PUT")
+	router.HandleFunc("/directors/{id}", s.deleteDirector).Methods("DELETE")
+	if s.metrics != nil {
+		router.Handle("/metrics", s.metrics).Methods("GET")
+	}
+
+	return router
+}
+
+// seed is loaded into a brand new store, unless -seed names another
+// catalog.
+var seed = catalog{
+	Directors: []Director{
+		{ID: "1", FirstName: "George", LastName: "Lucas"},
+		{ID: "2", FirstName: "Peter", LastName: "Jackson"},
+		{ID: "3", FirstName: "Christopher", LastName: "Nolan"},
+		{ID: "4", FirstName: "Lana", LastName: "Wachowski"},
+	},
+	Movies: []Movie{
+		{
+			ID: "1", ISBN: "9780345341464", Title: "Star Wars", DirectorID: "1",
+			ReleaseDate: "1977-05-25", Runtime: 121, Genres: []string{"Science Fiction", "Adventure"},
+			Cast: []CastMember{{Actor: "Mark Hamill", Role: "Luke Skywalker"}, {Actor: "Harrison Ford", Role: "Han Solo"}},
+		},
+		{
+			ID: "2", ISBN: "9780618640157", Title: "The Lord of the Rings", DirectorID: "2",
+			ReleaseDate: "2001-12-19", Runtime: 178, Genres: []string{"Fantasy", "Adventure"},
+			Cast: []CastMember{{Actor: "Elijah Wood", Role: "Frodo Baggins"}, {Actor: "Ian McKellen", Role: "Gandalf"}},
+		},
+		{
+			ID: "3", ISBN: "9780000001238", Title: "Inception", DirectorID: "3",
+			ReleaseDate: "2010-07-16", Runtime: 148, Genres: []string{"Science Fiction", "Thriller"},
+			Cast: []CastMember{{Actor: "Leonardo DiCaprio", Role: "Cobb"}, {Actor: "Elliot Page", Role: "Ariadne"}},
+		},
+		{
+			ID: "4", ISBN: "9780000004567", Title: "The Matrix", DirectorID: "4",
+			ReleaseDate: "1999-03-31", Runtime: 136, Genres: []string{"Science Fiction", "Action"},
+			Cast: []CastMember{{Actor: "Keanu Reeves", Role: "Neo"}, {Actor: "Carrie-Anne Moss", Role: "Trinity"}},
+		},
+	},
+}
+
+// loadSeed reads the catalog a new store starts with from path, in the
+// format of the -data file, or returns the sample catalog if path is empty.
+// The catalog is checked like movies and directors sent to the API.
+func loadSeed(path string) (catalog, error) {
+	if path == "" {
+		return seed, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return catalog{}, fmt.Errorf("reading seed: %w", err)
+	}
+	c, err := decodeCatalog(data)
+	if err != nil {
+		return catalog{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	fail := func(kind string, i int, errs []fieldError) error {
+		return fmt.Errorf("%s: %s %d: %s %s", path, kind, i+1, errs[0].Field, errs[0].Message)
+	}
+	directors := map[string]bool{}
+	for i, d := range c.Directors {
+		errs := d.validate("")
+		if d.ID == "" || directors[d.ID] {
+			errs = append(errs, fieldError{Field: "id", Message: "must be set and unique"})
+		}
+		if errs != nil {
+			return catalog{}, fail("director", i, errs)
 		}
+		directors[d.ID] = true
 	}
-	json.NewEncoder(w).Encode(movies)
+	movies := map[string]bool{}
+	for i, m := range c.Movies {
+		errs := m.validate()
+		if m.ID == "" || movies[m.ID] {
+			errs = append(errs, fieldError{Field: "id", Message: "must be set and unique"})
+		}
+		if !directors[m.DirectorID] {
+			errs = append(errs, fieldError{Field: "directorId", Message: "no director with this ID"})
+		}
+		if errs != nil {
+			return catalog{}, fail("movie", i, errs)
+		}
+		movies[m.ID] = true
+	}
+	return c, nil
 }
 
 func main() {
-	router := mux.NewRouter()
+	var st settings
+	st.register(flag.CommandLine)
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:], "MOVIES_", secretSettings...)
+	if err == nil {
+		err = st.validate()
+	}
+	if err != nil {
+		log.Fatalf("invalid config:\n%v", err)
+	}
+	if cfg.Print {
+		cfg.Write(os.Stdout)
+		return
+	}
+	logger, err := newLogger(os.Stderr, st.logFormat, st.logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logger)
 
-	// add some movies in the movies slice
-	movies = append(movies, Movie{ID: "1", ISBN: "438227", Title: "Star Wars", Director: &Director{FirstName: "George", LastName: "Lucas"}})
-	movies = append(movies, Movie{ID: "2", ISBN: "454555", Title: "The Lord of the Rings", Director: &Director{FirstName: "Peter", LastName: "Jackson"}})
-	movies = append(movies, Movie{ID: "3", ISBN: "123456", Title: "Inception", Director: &Director{FirstName: "Christopher", LastName: "Nolan"}})
-	movies = append(movies, Movie{ID: "4", ISBN: "654321", Title: "The Matrix", Director: &Director{FirstName: "Lana", LastName: "Wachowski"}})
+	auth, err := st.auth.policy()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if auth == nil {
+		slog.Warn("no API keys or JWT keys configured, anyone can change the catalog")
+	}
+	var roles *rolePolicy
+	if st.rolesFile != "" {
+		if roles, err = loadRoles(st.rolesFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var limiter *rateLimiter
+	if st.rateLimitsFile != "" {
+		routes, fallback, err := loadRateLimits(st.rateLimitsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		limiter = newRateLimiter(routes, fallback, rateLimitKey)
+	}
+	seed, err := loadSeed(st.seedFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var store Store
+	switch {
+	case st.dbFile != "":
+		db, err := newSQLiteStore(st.dbFile, seed)
+		if err != nil {
+			log.Fatal(err)
+		}
+		store = db
+	case st.dataFile != "":
+		fs, err := newFileStore(st.dataFile, seed)
+		if err != nil {
+			log.Fatal(err)
+		}
+		store = fs
+	default:
+		store = newMemoryStore(seed)
+	}
+	if n, err := addBaselines(store); err != nil {
+		log.Fatal(err)
+	} else if n > 0 {
+		slog.Info("started the history of movies without one", "movies", n)
+	}
 
-	router.HandleFunc("/movies", getMovies).Methods("GET")
-	router.HandleFunc("/movies/{id}", getMovie).Methods("GET")
-	router.HandleFunc("/movies", createMovie).Methods("POST")
-	router.HandleFunc("/movies/{id}", updateMovie).Methods("PUT")
-	router.HandleFunc("/movies/{id}", deleteMovie).Methods("DELETE")
+	movies, err := store.List()
+	if err != nil {
+		log.Fatal(err)
+	}
+	directors, err := store.ListDirectors()
+	if err != nil {
+		log.Fatal(err)
+	}
+	ids, err := newIDGenerator(st.idKind, maxNumericID(movies, directors))
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	fmt.Println("Starting server at port 8000")
-	log.Fatal(http.ListenAndServe(":8000", router))
+	index, err := newSearchIndex(store)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var stats *metrics
+	if st.metrics {
+		base := store // counting the directors for a scrape is not worth timing
+		stats = newMetrics(func() (int, int, error) {
+			directors, err := base.ListDirectors()
+			return index.size(), len(directors), err
+		})
+		store = timedStore{Store: store, metrics: stats}
+	}
+	// keep the search index in sync with every change to the store
+	store = indexedStore{Store: store, index: index}
+
+	// SIGINT (Ctrl+C) or SIGTERM starts a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop() // a second signal kills the server right away
+	}()
+
+	var purger sync.WaitGroup
+	if st.retention > 0 {
+		purger.Add(1)
+		go func() {
+			defer purger.Done()
+			purgeDeleted(ctx, store, st.retention, purgeInterval(st.retention))
+		}()
+	}
+
+	srv := newServer(store, ids, index)
+	srv.auth, srv.roles, srv.limit, srv.metrics = auth, roles, limiter, stats
+	router := srv.routes()
+	if roles != nil {
+		if err := roles.checkRoutes(router); err != nil {
+			log.Fatalf("%s: %v", st.rolesFile, err)
+		}
+	}
+	if srv.limit != nil {
+		if err := srv.limit.checkRoutes(router); err != nil {
+			log.Fatalf("%s: %v", st.rateLimitsFile, err)
+		}
+	}
+
+	handler := srv.probes(router)
+	if stats != nil {
+		handler = stats.middleware(handler)
+	}
+	var running inFlight
+	server := &http.Server{
+		Addr:              st.addr,
+		Handler:           logRequests(logger, running.middleware(handler)),
+		ReadHeaderTimeout: st.readHeaderTimeout,
+		ReadTimeout:       st.readTimeout,
+		WriteTimeout:      st.writeTimeout,
+		IdleTimeout:       st.idleTimeout,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
+	slog.Info("starting server", "addr", st.addr)
+	err = serve(ctx, server, st.shutdownDelay, st.shutdownTimeout, &srv.stopping)
+
+	// the handlers of requests cut off at the drain deadline may still be
+	// running: give them as long again before closing the store under them
+	stop()
+	purger.Wait()
+	if !running.wait(st.shutdownTimeout) {
+		slog.Error("requests still running, leaving the store open")
+	} else if closeErr := store.Close(); closeErr != nil {
+		slog.Error("closing the store", "err", closeErr)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.Info("server stopped")
 }
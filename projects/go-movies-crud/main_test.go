@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestServer returns a server on an in-memory store holding the sample
+// catalog, wired up like main does.
+func newTestServer(t *testing.T) *server {
+	t.Helper()
+	var store Store = newMemoryStore(seed)
+	if _, err := addBaselines(store); err != nil {
+		t.Fatal(err)
+	}
+	index, err := newSearchIndex(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store = indexedStore{Store: store, index: index}
+	return newServer(store, uuidGenerator{}, index)
+}
+
+// do sends a request to h and returns the response.
+func do(t *testing.T, h http.Handler, method, target, body string, header ...string) *http.Response {
+	t.Helper()
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, r)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Result()
+}
+
+func decodeBody(t *testing.T, resp *http.Response, v any) {
+	t.Helper()
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("decoding %s response: %v", resp.Status, err)
+	}
+}
+
+// TestConcurrentRoutes hammers the five movie routes from many goroutines
+// at once. Run with -race: the point is that the store and the search index
+// are safe to share between requests.
+func TestConcurrentRoutes(t *testing.T) {
+	srv := newTestServer(t)
+	h := srv.routes()
+
+	const workers, rounds = 16, 25
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*rounds)
+	check := func(resp *http.Response, what string, want ...int) {
+		resp.Body.Close()
+		for _, status := range want {
+			if resp.StatusCode == status {
+				return
+			}
+		}
+		errs <- fmt.Errorf("%s: status %d, want one of %v", what, resp.StatusCode, want)
+	}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				title := fmt.Sprintf("Movie %d-%d", w, i)
+				resp := do(t, h, "POST", "/movies", `{"isbn":"9780345341464","title":"`+title+`","directorId":"1"}`)
+				if resp.StatusCode != http.StatusCreated {
+					check(resp, "POST /movies", http.StatusCreated)
+					continue
+				}
+				var created Movie
+				decodeBody(t, resp, &created)
+				path := "/movies/" + created.ID
+
+				check(do(t, h, "GET", "/movies", ""), "GET /movies", http.StatusOK)
+				check(do(t, h, "GET", path, ""), "GET "+path, http.StatusOK)
+				check(do(t, h, "PUT", path, `{"isbn":"9780345341464","title":"`+title+` (cut)","directorId":"2"}`), "PUT "+path, http.StatusOK)
+				// everybody updates the same sample movie too
+				check(do(t, h, "PUT", "/movies/1", `{"isbn":"9780345341464","title":"Star Wars `+title+`","directorId":"1"}`), "PUT /movies/1", http.StatusOK)
+				check(do(t, h, "DELETE", path, ""), "DELETE "+path, http.StatusNoContent)
+				check(do(t, h, "GET", path, ""), "GET "+path+" after delete", http.StatusNotFound)
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// only the sample movies are left, and the index agrees
+	var movies []Movie
+	decodeBody(t, do(t, h, "GET", "/movies?limit=100", ""), &movies)
+	if len(movies) != len(seed.Movies) {
+		t.Errorf("%d movies left, want %d", len(movies), len(seed.Movies))
+	}
+	if n := srv.index.size(); n != len(seed.Movies) {
+		t.Errorf("%d movies indexed, want %d", n, len(seed.Movies))
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Bucket upper bounds, in seconds, of the latency histograms. Store
+// operations are mostly much faster than whole requests.
+var (
+	requestBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	storeBuckets   = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.5, 1}
+)
+
+// metrics collects what GET /metrics reports, in the Prometheus text format:
+//
+//	movies_http_requests_total{method,route,status}            counter
+//	movies_http_request_duration_seconds{method,route,status}  histogram
+//	movies_http_requests_in_flight                             gauge
+//	movies_catalog_movies, movies_catalog_directors            gauges
+//	movies_store_operation_duration_seconds{operation}         histogram
+//
+// route is the route template, like /movies/{id}, so the number of series
+// doesn't grow with the catalog, or the path of a probe (/healthz, /readyz,
+// /version). Requests that match neither have the route "unmatched".
+type metrics struct {
+	inFlight atomic.Int64
+
+	mu       sync.Mutex
+	requests map[requestLabels]*histogram
+	store    map[string]*histogram // by operation
+
+	// catalog counts the movies (not deleted) and directors when the metrics
+	// are scraped.
+	catalog func() (movies, directors int, err error)
+}
+
+type requestLabels struct {
+	method, route string
+	status        int
+}
+
+func newMetrics(catalog func() (movies, directors int, err error)) *metrics {
+	return &metrics{
+		requests: map[requestLabels]*histogram{},
+		store:    map[string]*histogram{},
+		catalog:  catalog,
+	}
+}
+
+// histogram counts observations in buckets by upper bound. counts holds one
+// more bucket than bounds, for +Inf, and isn't cumulative: writing it adds
+// the buckets up.
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	h.counts[sort.SearchFloat64s(h.bounds, v)]++
+	h.sum += v
+	h.count++
+}
+
+// middleware counts and times every request, by the route that recordRoute
+// or probes notes in the requestInfo of logRequests. Outside logRequests it
+// adds a requestInfo of its own for them.
+func (m *metrics) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo)
+		if !ok {
+			info = &requestInfo{}
+			r = r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+		}
+		m.inFlight.Add(1)
+		defer m.inFlight.Add(-1)
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+		completed := false
+		defer func() {
+			// count requests cut short by a panic as 500s, unless a status
+			// was already sent
+			if !completed && rec.status == 0 {
+				rec.status = http.StatusInternalServerError
+			}
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			labels := requestLabels{method: r.Method, route: info.route, status: rec.status}
+			if labels.route == "" {
+				labels.route = "unmatched"
+			}
+			m.observeRequest(labels, time.Since(start))
+		}()
+		next.ServeHTTP(rec, r)
+		completed = true
+	})
+}
+
+func (m *metrics) observeRequest(labels requestLabels, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.requests[labels]
+	if !ok {
+		h = newHistogram(requestBuckets)
+		m.requests[labels] = h
+	}
+	h.observe(d.Seconds())
+}
+
+func (m *metrics) observeStore(operation string, start time.Time) {
+	d := time.Since(start)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.store[operation]
+	if !ok {
+		h = newHistogram(storeBuckets)
+		m.store[operation] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// ServeHTTP writes every metric in the Prometheus text exposition format.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	movies, directors, err := m.catalog()
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.write(w, movies, directors)
+}
+
+func (m *metrics) write(w io.Writer, movies, directors int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	requests := make([]requestLabels, 0, len(m.requests))
+	for labels := range m.requests {
+		requests = append(requests, labels)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		a, b := requests[i], requests[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+	labelsOf := func(l requestLabels) string {
+		return fmt.Sprintf(`method="%s",route="%s",status="%d"`, escapeLabel(l.method), escapeLabel(l.route), l.status)
+	}
+
+	writeHeader(w, "movies_http_requests_total", "counter", "HTTP requests handled, by route template and status.")
+	for _, l := range requests {
+		fmt.Fprintf(w, "movies_http_requests_total{%s} %d\n", labelsOf(l), m.requests[l].count)
+	}
+	writeHeader(w, "movies_http_request_duration_seconds", "histogram", "Time taken to handle HTTP requests, by route template and status.")
+	for _, l := range requests {
+		m.requests[l].write(w, "movies_http_request_duration_seconds", labelsOf(l))
+	}
+	writeHeader(w, "movies_http_requests_in_flight", "gauge", "HTTP requests being handled right now.")
+	fmt.Fprintf(w, "movies_http_requests_in_flight %d\n", m.inFlight.Load())
+
+	writeHeader(w, "movies_catalog_movies", "gauge", "Movies in the catalog, not counting deleted ones.")
+	fmt.Fprintf(w, "movies_catalog_movies %d\n", movies)
+	writeHeader(w, "movies_catalog_directors", "gauge", "Directors in the catalog.")
+	fmt.Fprintf(w, "movies_catalog_directors %d\n", directors)
+
+	operations := make([]string, 0, len(m.store))
+	for op := range m.store {
+		operations = append(operations, op)
+	}
+	sort.Strings(operations)
+	writeHeader(w, "movies_store_operation_duration_seconds", "histogram", "Time taken by store operations.")
+	for _, op := range operations {
+		m.store[op].write(w, "movies_store_operation_duration_seconds", `operation="`+escapeLabel(op)+`"`)
+	}
+}
+
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// write writes the series of h: the cumulative buckets, the sum and the
+// count.
+func (h *histogram) write(w io.Writer, name, labels string) {
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+// timedStore wraps a Store and times every operation for metrics,
+// including the ones made inside a transaction.
+type timedStore struct {
+	Store
+	metrics *metrics
+}
+
+func (s timedStore) List() ([]Movie, error) {
+	defer s.metrics.observeStore("List", time.Now())
+	return s.Store.List()
+}
+
+func (s timedStore) Walk(fn func(Movie) error) error {
+	defer s.metrics.observeStore("Walk", time.Now())
+	return s.Store.Walk(fn)
+}
+
+func (s timedStore) Get(id string) (Movie, error) {
+	defer s.metrics.observeStore("Get", time.Now())
+	return s.Store.Get(id)
+}
+
+func (s timedStore) Create(movie Movie) (Movie, error) {
+	defer s.metrics.observeStore("Create", time.Now())
+	return s.Store.Create(movie)
+}
+
+func (s timedStore) Update(id string, movie Movie) (Movie, error) {
+	defer s.metrics.observeStore("Update", time.Now())
+	return s.Store.Update(id, movie)
+}
+
+func (s timedStore) Delete(id string, version int) error {
+	defer s.metrics.observeStore("Delete", time.Now())
+	return s.Store.Delete(id, version)
+}
+
+func (s timedStore) Restore(id string) (Movie, error) {
+	defer s.metrics.observeStore("Restore", time.Now())
+	return s.Store.Restore(id)
+}
+
+func (s timedStore) Purge(deletedBefore time.Time) (int, error) {
+	defer s.metrics.observeStore("Purge", time.Now())
+	return s.Store.Purge(deletedBefore)
+}
+
+func (s timedStore) ListDirectors() ([]Director, error) {
+	defer s.metrics.observeStore("ListDirectors", time.Now())
+	return s.Store.ListDirectors()
+}
+
+func (s timedStore) GetDirector(id string) (Director, error) {
+	defer s.metrics.observeStore("GetDirector", time.Now())
+	return s.Store.GetDirector(id)
+}
+
+func (s timedStore) CreateDirector(director Director) (Director, error) {
+	defer s.metrics.observeStore("CreateDirector", time.Now())
+	return s.Store.CreateDirector(director)
+}
+
+func (s timedStore) UpdateDirector(id string, director Director) (Director, error) {
+	defer s.metrics.observeStore("UpdateDirector", time.Now())
+	return s.Store.UpdateDirector(id, director)
+}
+
+func (s timedStore) DeleteDirector(id string, cascade bool) error {
+	defer s.metrics.observeStore("DeleteDirector", time.Now())
+	return s.Store.DeleteDirector(id, cascade)
+}
+
+func (s timedStore) AddEvent(event MovieEvent) error {
+	defer s.metrics.observeStore("AddEvent", time.Now())
+	return s.Store.AddEvent(event)
+}
+
+func (s timedStore) History(movieID string) ([]MovieEvent, error) {
+	defer s.metrics.observeStore("History", time.Now())
+	return s.Store.History(movieID)
+}
+
+// Atomically times the whole transaction, as well as what fn does in it.
+func (s timedStore) Atomically(fn func(Store) error) error {
+	defer s.metrics.observeStore("Atomically", time.Now())
+	return s.Store.Atomically(func(tx Store) error {
+		return fn(timedStore{Store: tx, metrics: s.metrics})
+	})
+}
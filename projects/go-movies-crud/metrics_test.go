@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newMetricsServer returns the handler chain main builds with -metrics,
+// with or without the access log around it.
+func newMetricsServer(t *testing.T, logged bool) http.Handler {
+	t.Helper()
+	var store Store = newMemoryStore(seed)
+	if _, err := addBaselines(store); err != nil {
+		t.Fatal(err)
+	}
+	index, err := newSearchIndex(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := store
+	stats := newMetrics(func() (int, int, error) {
+		directors, err := base.ListDirectors()
+		return index.size(), len(directors), err
+	})
+	store = indexedStore{Store: timedStore{Store: store, metrics: stats}, index: index}
+	srv := newServer(store, uuidGenerator{}, index)
+	srv.metrics = stats
+
+	h := stats.middleware(srv.probes(srv.routes()))
+	if logged {
+		h = logRequests(slog.New(slog.NewTextHandler(io.Discard, nil)), h)
+	}
+	return h
+}
+
+// scrape returns the value of every series GET /metrics reports, by name
+// and labels.
+func scrape(t *testing.T, h http.Handler) map[string]float64 {
+	t.Helper()
+	resp := do(t, h, "GET", "/metrics", "")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /metrics: status %d", resp.StatusCode)
+	}
+	series := map[string]float64{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("bad line %q", line)
+		}
+		series[line[:i]] = value
+	}
+	return series
+}
+
+func TestMetrics(t *testing.T) {
+	for _, logged := range []bool{true, false} {
+		h := newMetricsServer(t, logged)
+		for _, target := range []string{"/movies", "/movies", "/movies/1", "/movies/99", "/healthz", "/readyz", "/version", "/nope"} {
+			do(t, h, "GET", target, "")
+		}
+		series := scrape(t, h)
+
+		want := map[string]float64{
+			`movies_http_requests_total{method="GET",route="/movies",status="200"}`:      2,
+			`movies_http_requests_total{method="GET",route="/movies/{id}",status="200"}`: 1,
+			`movies_http_requests_total{method="GET",route="/movies/{id}",status="404"}`: 1,
+			`movies_http_requests_total{method="GET",route="/healthz",status="200"}`:     1,
+			`movies_http_requests_total{method="GET",route="/readyz",status="200"}`:      1,
+			`movies_http_requests_total{method="GET",route="/version",status="200"}`:     1,
+			`movies_http_requests_total{method="GET",route="unmatched",status="404"}`:    1,
+
+			`movies_http_request_duration_seconds_count{method="GET",route="/movies",status="200"}`:            2,
+			`movies_http_request_duration_seconds_bucket{method="GET",route="/movies",status="200",le="+Inf"}`: 2,
+
+			`movies_http_requests_in_flight`: 1, // the scrape
+			`movies_catalog_movies`:          float64(len(seed.Movies)),
+			`movies_catalog_directors`:       float64(len(seed.Directors)),
+		}
+		for name, value := range want {
+			if got, ok := series[name]; !ok || got != value {
+				t.Errorf("logged %v: %s = %v (reported %v), want %v", logged, name, got, ok, value)
+			}
+		}
+		if series[`movies_store_operation_duration_seconds_count{operation="Get"}`] < 1 {
+			t.Errorf("logged %v: no store Get was timed", logged)
+		}
+
+		// the buckets of a histogram add up
+		prefix := `movies_http_request_duration_seconds_bucket{method="GET",route="/movies",status="200",le="`
+		last := 0.0
+		for _, bound := range requestBuckets {
+			name := prefix + strconv.FormatFloat(bound, 'g', -1, 64) + `"}`
+			got, ok := series[name]
+			if !ok || got < last {
+				t.Errorf("logged %v: %s = %v (reported %v), want at least %v", logged, name, got, ok, last)
+			}
+			last = got
+		}
+	}
+}
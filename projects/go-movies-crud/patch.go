@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"reflect"
+
+	"github.com/gorilla/mux"
+)
+
+// mergePatchType is the media type of an RFC 7396 JSON Merge Patch.
+const mergePatchType = "application/merge-patch+json"
+
+// patchMovie applies a JSON Merge Patch (RFC 7396) to a movie. Fields in the
+// patch replace the stored ones and a null removes a field:
+//
+//	PATCH /movies/1
+//	{"title": "Star Wars: A New Hope", "directorId": "7"}
+//
+// The embedded "director" is only a copy of the director resource. A patch
+// that sets "director" is merged onto that copy and picks the movie's
+// director by the resulting name, like a POST with an embedded director
+// does, so {"director": {"firstName": "G."}} moves the movie to G. Lucas.
+// The director resource itself is never renamed by a movie patch.
+//
+// With an If-Match header the patch only applies if the movie hasn't changed
+// since the client read it.
+func (s *server) patchMovie(w http.ResponseWriter, r *http.Request) {
+	// plain application/json is accepted too, for clients that can't set
+	// a custom content type
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, _ := mime.ParseMediaType(ct)
+		if mediaType != mergePatchType && mediaType != "application/json" {
+			w.Header().Set("Accept-Patch", mergePatchType)
+			writeError(w, http.StatusUnsupportedMediaType, codeUnsupportedMediaType,
+				"PATCH body must be "+mergePatchType)
+			return
+		}
+	}
+
+	var patch map[string]any
+	if _, ok := decodeJSON(w, r, &patch); !ok {
+		return
+	}
+
+	params := mux.Vars(r)
+	for attempt := 0; ; attempt++ {
+		current, err := s.getLive(params["id"])
+		if errors.Is(err, ErrMovieNotFound) {
+			writeError(w, http.StatusNotFound, codeNotFound, "movie not found")
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if !checkIfMatch(w, r, current) {
+			return
+		}
+
+		movie, unknown, err := applyMergePatch(current, patch)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "invalid patch: "+err.Error())
+			return
+		}
+		if movie.ID != current.ID {
+			writeValidationError(w, []fieldError{{Field: "id", Message: "cannot be changed"}})
+			return
+		}
+		if errs := append(unknown, s.validateUpdate(current.ID, movie)...); errs != nil {
+			writeValidationError(w, errs)
+			return
+		}
+
+		// the patch was applied to this version, so only store it on top
+		// of this version
+		movie.Version = current.Version
+		movie, err = s.change(r, func(tx *server) (MovieEvent, error) {
+			updated, err := tx.update(current.ID, movie)
+			return MovieEvent{Action: actionUpdate, Movie: updated}, err
+		})
+		if errors.Is(err, ErrVersionMismatch) {
+			if ifMatch(r) != "" {
+				writePreconditionFailed(w)
+				return
+			}
+			if attempt < maxConflictRetries {
+				continue // changed while we were patching it: patch the new version
+			}
+			writeBusy(w)
+			return
+		}
+		if errors.Is(err, ErrMovieNotFound) {
+			// deleted while we were patching it
+			writeError(w, http.StatusNotFound, codeNotFound, "movie not found")
+			return
+		}
+		if errors.Is(err, ErrDirectorNotFound) {
+			writeUnknownDirector(w)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		writeMovie(w, http.StatusOK, movie)
+		return
+	}
+}
+
+// applyMergePatch returns movie with patch merged into it, and the fields
+// the patch adds that a Movie doesn't have.
+func applyMergePatch(movie Movie, patch map[string]any) (Movie, []fieldError, error) {
+	_, setsDirector := patch["director"]
+	_, setsDirectorID := patch["directorId"]
+	if setsDirector && !setsDirectorID && movie.Director != nil {
+		// merge the patch onto the current director's name, without its ID,
+		// and let resolveDirector pick the director by the merged name
+		director := *movie.Director
+		director.ID = ""
+		movie.Director = &director
+		movie.DirectorID = ""
+	} else {
+		movie.Director = nil
+		if setsDirector && !setsDirectorID {
+			movie.DirectorID = ""
+		}
+	}
+
+	data, err := json.Marshal(movie)
+	if err != nil {
+		return Movie{}, nil, err
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return Movie{}, nil, err
+	}
+
+	merged, err := json.Marshal(mergePatch(doc, patch))
+	if err != nil {
+		return Movie{}, nil, err
+	}
+	var result Movie
+	if err := json.Unmarshal(merged, &result); err != nil {
+		return Movie{}, nil, err
+	}
+	return result, unknownFields(merged, reflect.TypeOf(result)), nil
+}
+
+// mergePatch implements the MergePatch function from RFC 7396 section 2.
+func mergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		// anything that isn't an object replaces the target as a whole
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+	for name, value := range p {
+		if value == nil {
+			delete(t, name)
+		} else {
+			t[name] = mergePatch(t[name], value)
+		}
+	}
+	return t
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPatchMergesDirector(t *testing.T) {
+	srv := newTestServer(t)
+	h := srv.routes()
+
+	tests := []struct {
+		patch           string
+		first, last, id string // the director the movie ends up with; id "" is a new one
+	}{
+		{`{"director":{"lastName":"Lucas2"}}`, "George", "Lucas2", ""},
+		{`{"title":"Star Wars: A New Hope","director":{"firstName":"G."}}`, "G.", "Lucas2", ""},
+		{`{"director":{"firstName":"George","lastName":"Lucas"}}`, "George", "Lucas", "1"},
+		{`{"directorId":"2"}`, "Peter", "Jackson", "2"},
+		{`{"director":{"id":"1"}}`, "George", "Lucas", "1"},
+	}
+	for _, tt := range tests {
+		resp := do(t, h, "PATCH", "/movies/1", tt.patch)
+		if resp.StatusCode != http.StatusOK {
+			var body errorResponse
+			decodeBody(t, resp, &body)
+			t.Fatalf("PATCH %s: status %d (%+v), want 200", tt.patch, resp.StatusCode, body.Error)
+		}
+		var movie Movie
+		decodeBody(t, resp, &movie)
+		d := movie.Director
+		if d == nil || d.FirstName != tt.first || d.LastName != tt.last || (tt.id != "" && d.ID != tt.id) {
+			t.Errorf("PATCH %s: director = %+v, want %s %s (id %q)", tt.patch, d, tt.first, tt.last, tt.id)
+		}
+	}
+
+	// the movie patches picked other directors; none was renamed
+	director, err := srv.store.GetDirector("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if director.FirstName != "George" || director.LastName != "Lucas" {
+		t.Errorf("director 1 = %+v, want George Lucas unchanged", director)
+	}
+}
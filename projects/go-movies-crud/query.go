@@ -0,0 +1,387 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// movieField is a field GET /movies can filter (and maybe sort) on.
+type movieField struct {
+	// values reads the field from a movie. Most fields have one value;
+	// lists such as genres have one per entry, and a filter matches if any
+	// of them does. Missing values are left out.
+	values func(Movie) []string
+	// numeric fields are compared as numbers instead of strings
+	numeric bool
+	// only single-valued fields can be sorted on
+	sortable bool
+}
+
+func text(get func(Movie) string) movieField {
+	return movieField{
+		values: func(m Movie) []string {
+			if v := get(m); v != "" {
+				return []string{v}
+			}
+			return nil
+		},
+		sortable: true,
+	}
+}
+
+func number(get func(Movie) (float64, bool)) movieField {
+	return movieField{
+		values: func(m Movie) []string {
+			if n, ok := get(m); ok {
+				return []string{strconv.FormatFloat(n, 'f', -1, 64)}
+			}
+			return nil
+		},
+		numeric:  true,
+		sortable: true,
+	}
+}
+
+// movieFields are the fields GET /movies can sort and filter on.
+var movieFields = map[string]movieField{
+	"id":          text(func(m Movie) string { return m.ID }),
+	"isbn":        text(func(m Movie) string { return m.ISBN }),
+	"title":       text(func(m Movie) string { return m.Title }),
+	"directorId":  text(func(m Movie) string { return m.DirectorID }),
+	"releaseDate": text(func(m Movie) string { return m.ReleaseDate }),
+	"director.firstName": text(func(m Movie) string {
+		if m.Director == nil {
+			return ""
+		}
+		return m.Director.FirstName
+	}),
+	"director.lastName": text(func(m Movie) string {
+		if m.Director == nil {
+			return ""
+		}
+		return m.Director.LastName
+	}),
+	"year": number(func(m Movie) (float64, bool) {
+		if len(m.ReleaseDate) < 4 {
+			return 0, false
+		}
+		year, err := strconv.Atoi(m.ReleaseDate[:4])
+		return float64(year), err == nil
+	}),
+	"runtime": number(func(m Movie) (float64, bool) {
+		return float64(m.Runtime), m.Runtime > 0
+	}),
+	"rating": number(func(m Movie) (float64, bool) {
+		avg, n := averageRating(m.Ratings)
+		return avg, n > 0
+	}),
+	"genre": {values: func(m Movie) []string { return m.Genres }},
+	"cast.actor": {values: func(m Movie) []string {
+		var actors []string
+		for _, c := range m.Cast {
+			actors = append(actors, c.Actor)
+		}
+		return actors
+	}},
+	"cast.role": {values: func(m Movie) []string {
+		var roles []string
+		for _, c := range m.Cast {
+			roles = append(roles, c.Role)
+		}
+		return roles
+	}},
+}
+
+// maxLimit is the largest page a client may ask for.
+const maxLimit = 1000
+
+// listQuery is a parsed GET /movies query string.
+type listQuery struct {
+	limit          int // 0 = no limit
+	offset         int
+	after          string // cursor: only return movies after this ID
+	sort           []sortKey
+	filter         []filter
+	includeDeleted bool
+}
+
+type sortKey struct {
+	field string
+	desc  bool
+}
+
+// Filter operators. Go's URL parser splits "runtime>=120" into the key
+// "runtime>" and the value "120", so the operator is the key's last rune.
+const (
+	opEqual    = '='
+	opContains = '~'
+	opAtLeast  = '>'
+	opAtMost   = '<'
+)
+
+type filter struct {
+	field string
+	op    rune
+	value string
+}
+
+// parseListQuery reads the pagination, sort and filter parameters:
+//
+//	?limit=10&offset=20          page by position
+//	?limit=10&after=3            page by cursor (the last ID of the previous page)
+//	?sort=title,-id              sort by title, then by ID descending
+//	?director.lastName=Nolan     exact match (case-insensitive)
+//	?title~=matrix               substring match (case-insensitive)
+//	?year>=2000&runtime<=120     ranges, numeric for numeric fields
+//	?includeDeleted=true         list deleted movies too
+func parseListQuery(values url.Values) (listQuery, error) {
+	var q listQuery
+	for key, vals := range values {
+		value := vals[len(vals)-1]
+		switch key {
+		case "limit":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > maxLimit {
+				return q, fmt.Errorf("limit must be a number between 1 and %d", maxLimit)
+			}
+			q.limit = n
+		case "offset":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return q, fmt.Errorf("offset must be a number >= 0")
+			}
+			q.offset = n
+		case "after":
+			q.after = value
+		case "includeDeleted":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return q, fmt.Errorf("includeDeleted must be true or false")
+			}
+			q.includeDeleted = b
+		case "sort":
+			for _, name := range strings.Split(value, ",") {
+				key := sortKey{field: name}
+				if strings.HasPrefix(name, "-") {
+					key = sortKey{field: name[1:], desc: true}
+				}
+				if f, ok := movieFields[key.field]; !ok || !f.sortable {
+					return q, fmt.Errorf("cannot sort by %q", key.field)
+				}
+				q.sort = append(q.sort, key)
+			}
+		default:
+			f := filter{field: key, op: opEqual, value: value}
+			for _, op := range []rune{opContains, opAtLeast, opAtMost} {
+				if strings.HasSuffix(key, string(op)) {
+					f = filter{field: strings.TrimSuffix(key, string(op)), op: op, value: value}
+				}
+			}
+			field, ok := movieFields[f.field]
+			if !ok {
+				return q, fmt.Errorf("unknown query parameter %q", key)
+			}
+			if field.numeric {
+				if f.op == opContains {
+					return q, fmt.Errorf("%s is a number and can't be matched with ~=", f.field)
+				}
+				if _, err := strconv.ParseFloat(value, 64); err != nil {
+					return q, fmt.Errorf("%s must be a number", f.field)
+				}
+			}
+			q.filter = append(q.filter, f)
+		}
+	}
+	if q.after != "" && q.offset != 0 {
+		return q, fmt.Errorf("use either offset or after, not both")
+	}
+	return q, nil
+}
+
+// match reports whether the movie passes every filter.
+func (q listQuery) match(m Movie) bool {
+	if m.DeletedAt != nil && !q.includeDeleted {
+		return false
+	}
+	for _, f := range q.filter {
+		field := movieFields[f.field]
+		found := false
+		for _, value := range field.values(m) {
+			if f.matches(value, field.numeric) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// matches applies the filter to one value of its field.
+func (f filter) matches(value string, numeric bool) bool {
+	cmp := 0
+	if numeric {
+		got, _ := strconv.ParseFloat(value, 64)
+		want, _ := strconv.ParseFloat(f.value, 64)
+		switch {
+		case got < want:
+			cmp = -1
+		case got > want:
+			cmp = 1
+		}
+	} else {
+		value = strings.ToLower(value)
+		if f.op == opContains {
+			return strings.Contains(value, strings.ToLower(f.value))
+		}
+		cmp = strings.Compare(value, strings.ToLower(f.value))
+	}
+
+	switch f.op {
+	case opAtLeast:
+		return cmp >= 0
+	case opAtMost:
+		return cmp <= 0
+	}
+	return cmp == 0
+}
+
+// less orders two movies by the sort keys. Movies without a value for a
+// key go last. Movies that compare equal keep their store order, since the
+// caller uses a stable sort.
+func (q listQuery) less(a, b Movie) bool {
+	for _, key := range q.sort {
+		field := movieFields[key.field]
+		x, y := field.values(a), field.values(b)
+		switch {
+		case len(x) == 0 && len(y) == 0:
+			continue
+		case len(x) == 0:
+			return false
+		case len(y) == 0:
+			return true
+		}
+
+		cmp := strings.Compare(x[0], y[0])
+		if field.numeric {
+			fx, _ := strconv.ParseFloat(x[0], 64)
+			fy, _ := strconv.ParseFloat(y[0], 64)
+			cmp = 0
+			if fx < fy {
+				cmp = -1
+			} else if fx > fy {
+				cmp = 1
+			}
+		}
+		if cmp == 0 {
+			continue
+		}
+		if key.desc {
+			return cmp > 0
+		}
+		return cmp < 0
+	}
+	return false
+}
+
+// page is one page of results plus what's needed for the response headers.
+type page struct {
+	movies  []Movie
+	total   int    // movies matching the filters, across all pages
+	hasNext bool   // there are more movies after this page
+	last    string // ID of the last movie on the page, for the next cursor
+}
+
+// apply filters, sorts and paginates the movies.
+func (q listQuery) apply(movies []Movie) page {
+	matched := movies[:0:0]
+	for _, m := range movies {
+		if q.match(m) {
+			matched = append(matched, m)
+		}
+	}
+	if len(q.sort) > 0 {
+		sort.SliceStable(matched, func(i, j int) bool { return q.less(matched[i], matched[j]) })
+	}
+
+	p := page{total: len(matched)}
+	start := q.offset
+	if q.after != "" {
+		// an unknown cursor means the movie is gone: return an empty page
+		start = len(matched)
+		for i, m := range matched {
+			if m.ID == q.after {
+				start = i + 1
+				break
+			}
+		}
+	}
+	if start > len(matched) {
+		start = len(matched)
+	}
+	end := len(matched)
+	if q.limit > 0 && start+q.limit < end {
+		end = start + q.limit
+	}
+
+	p.movies = matched[start:end]
+	p.hasNext = end < len(matched)
+	if len(p.movies) > 0 {
+		p.last = p.movies[len(p.movies)-1].ID
+	}
+	return p
+}
+
+// queryBool reads a true/false query parameter, which defaults to false.
+func queryBool(r *http.Request, name string) (bool, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false", name)
+	}
+	return b, nil
+}
+
+// setPageHeaders adds X-Total-Count and an RFC 8288 Link header with the
+// next and previous pages, when there are any.
+func setPageHeaders(w http.ResponseWriter, r *http.Request, q listQuery, p page) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(p.total))
+	if q.limit == 0 {
+		return
+	}
+
+	link := func(rel string, change func(url.Values)) string {
+		values := r.URL.Query()
+		change(values)
+		u := url.URL{Path: r.URL.Path, RawQuery: values.Encode()}
+		return fmt.Sprintf("<%s>; rel=%q", u.String(), rel)
+	}
+
+	var links []string
+	if p.hasNext {
+		if q.after != "" {
+			links = append(links, link("next", func(v url.Values) { v.Set("after", p.last) }))
+		} else {
+			links = append(links, link("next", func(v url.Values) { v.Set("offset", strconv.Itoa(q.offset+q.limit)) }))
+		}
+	}
+	if q.after == "" && q.offset > 0 {
+		prev := q.offset - q.limit
+		if prev < 0 {
+			prev = 0
+		}
+		links = append(links, link("prev", func(v url.Values) { v.Set("offset", strconv.Itoa(prev)) }))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
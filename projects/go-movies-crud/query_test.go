@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestListQuery(t *testing.T) {
+	srv := newTestServer(t)
+	h := srv.routes()
+	// a second Nolan movie, so sorting by director has a tie to break
+	resp := do(t, h, "POST", "/movies", `{"id":"5","isbn":"9780345341464","title":"Interstellar","directorId":"3",
+		"releaseDate":"2014-11-07","runtime":169,"genres":["Science Fiction","Drama"]}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /movies: status %d", resp.StatusCode)
+	}
+
+	tests := []struct {
+		query string
+		want  []string // IDs, in order
+		total int
+	}{
+		{"", []string{"1", "2", "3", "4", "5"}, 5},
+		{"sort=-id", []string{"5", "4", "3", "2", "1"}, 5},
+		{"sort=director.lastName,-year", []string{"2", "1", "5", "3", "4"}, 5},
+		{"sort=runtime", []string{"1", "4", "3", "5", "2"}, 5},
+		{"director.lastName=NOLAN", []string{"3", "5"}, 2},
+		{"title=the+matrix", []string{"4"}, 1},
+		{"title~=THE", []string{"2", "4"}, 2},
+		{"cast.actor~=reeves", []string{"4"}, 1},
+		{"year>=2001", []string{"2", "3", "5"}, 3},
+		{"runtime<=136", []string{"1", "4"}, 2},
+		{"genre=adventure", []string{"1", "2"}, 2},
+		{"genre=science+fiction&year<=2000", []string{"1", "4"}, 2},
+		{"year>=2000&year<=2010&sort=-year", []string{"3", "2"}, 2},
+		{"limit=2", []string{"1", "2"}, 5},
+		{"limit=2&offset=4", []string{"5"}, 5},
+		{"offset=9", []string{}, 5},
+		{"limit=2&after=2", []string{"3", "4"}, 5},
+		{"sort=-year&limit=2&after=5", []string{"3", "2"}, 5},
+		{"after=99", []string{}, 5}, // the cursor's movie is gone
+	}
+	for _, tt := range tests {
+		resp := do(t, h, "GET", "/movies?"+tt.query, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET /movies?%s: status %d, want 200", tt.query, resp.StatusCode)
+			continue
+		}
+		if got := resp.Header.Get("X-Total-Count"); got != strconv.Itoa(tt.total) {
+			t.Errorf("GET /movies?%s: X-Total-Count %s, want %d", tt.query, got, tt.total)
+		}
+		var movies []Movie
+		decodeBody(t, resp, &movies)
+		ids := []string{}
+		for _, m := range movies {
+			ids = append(ids, m.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.want) {
+			t.Errorf("GET /movies?%s = %v, want %v", tt.query, ids, tt.want)
+		}
+	}
+}
+
+func TestListQueryErrors(t *testing.T) {
+	h := newTestServer(t).routes()
+	for _, query := range []string{
+		"sort=genre", // a list
+		"sort=nope",
+		"sort=title,",
+		"nope=1",
+		"year>=soon",
+		"year~=19", // a number
+		"limit=0",
+		"limit=1001",
+		"limit=ten",
+		"offset=-1",
+		"offset=1&after=2",
+		"includeDeleted=maybe",
+	} {
+		resp := do(t, h, "GET", "/movies?"+query, "")
+		var body errorResponse
+		decodeBody(t, resp, &body)
+		if resp.StatusCode != http.StatusBadRequest || body.Error.Code != codeBadRequest {
+			t.Errorf("GET /movies?%s: status %d, code %q; want 400 %s", query, resp.StatusCode, body.Error.Code, codeBadRequest)
+		}
+	}
+}
+
+// Pages link to the next and previous ones, keeping the rest of the query.
+func TestListLinks(t *testing.T) {
+	h := newTestServer(t).routes()
+	tests := []struct {
+		query, want string
+	}{
+		{"limit=2", `</movies?limit=2&offset=2>; rel="next"`},
+		{"limit=1&offset=1&sort=id", `</movies?limit=1&offset=2&sort=id>; rel="next", </movies?limit=1&offset=0&sort=id>; rel="prev"`},
+		{"limit=3&offset=2", `</movies?limit=3&offset=0>; rel="prev"`},
+		{"limit=1&after=2", `</movies?after=3&limit=1>; rel="next"`},
+		{"limit=2&after=2", ""}, // the last page
+		{"limit=10", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		resp := do(t, h, "GET", "/movies?"+tt.query, "")
+		resp.Body.Close()
+		if got := resp.Header.Get("Link"); got != tt.want {
+			t.Errorf("GET /movies?%s: Link %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
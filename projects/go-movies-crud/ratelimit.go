@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// rateLimit lets a client make burst requests at once, and then rate
+// requests a second as its bucket fills up again.
+type rateLimit struct {
+	rate  float64 // tokens a second; 0 means no limit
+	burst int
+}
+
+// rateLimitRule is how the -rate-limits file spells a limit:
+//
+//	{
+//	  "default": {"rate": "10/s", "burst": 20},
+//	  "routes": [
+//	    {"route": "POST /movies:import", "rate": "2/m", "burst": 2},
+//	    {"route": "GET *", "rate": "50/s", "burst": 100}
+//	  ]
+//	}
+//
+// Routes are rules like the ones of the roles file, and the first one that
+// matches a request sets its limit. A rate is a number of requests per
+// second, minute or hour, or "off". The burst defaults to one second's worth
+// of requests, and at least one.
+type rateLimitRule struct {
+	Route string `json:"route,omitempty"`
+	Rate  string `json:"rate"`
+	Burst int    `json:"burst,omitempty"`
+}
+
+type rateLimitsFile struct {
+	Default *rateLimitRule  `json:"default"`
+	Routes  []rateLimitRule `json:"routes"`
+}
+
+// parse turns the rate and burst of r into a rateLimit.
+func (r rateLimitRule) parse() (rateLimit, error) {
+	if r.Rate == "off" {
+		return rateLimit{}, nil
+	}
+	count, unit, ok := strings.Cut(r.Rate, "/")
+	n, err := strconv.ParseFloat(count, 64)
+	per := map[string]float64{"s": 1, "m": 60, "h": 3600}[unit]
+	if !ok || err != nil || n <= 0 || math.IsInf(n, 0) || per == 0 {
+		return rateLimit{}, fmt.Errorf("rate %q must look like 10/s, 600/m or 1000/h, or be off", r.Rate)
+	}
+	if r.Burst < 0 {
+		return rateLimit{}, fmt.Errorf("burst must not be negative")
+	}
+	limit := rateLimit{rate: n / per, burst: r.Burst}
+	if limit.burst == 0 {
+		limit.burst = max(1, int(math.Ceil(limit.rate)))
+	}
+	return limit, nil
+}
+
+// limitedRoute is a route rule with its limit.
+type limitedRoute struct {
+	rule  routeRule
+	limit rateLimit
+}
+
+// rateLimiter is the rate limiting middleware. It keeps a token bucket for
+// every client on every rule, and drops the buckets that have filled up
+// again, which are no different from new ones, so idle clients cost nothing.
+type rateLimiter struct {
+	routes   []limitedRoute
+	fallback rateLimit
+	client   func(r *http.Request) string // who the bucket is for
+
+	mu        sync.Mutex
+	buckets   map[bucketKey]*bucket
+	lastSweep time.Time
+}
+
+type bucketKey struct {
+	route  int // index in routes, or len(routes) for the fallback
+	client string
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time // when tokens was last brought up to date
+}
+
+// sweepInterval is how often full buckets are dropped.
+const sweepInterval = time.Minute
+
+func newRateLimiter(routes []limitedRoute, fallback rateLimit, client func(*http.Request) string) *rateLimiter {
+	return &rateLimiter{
+		routes:    routes,
+		fallback:  fallback,
+		client:    client,
+		buckets:   map[bucketKey]*bucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+// loadRateLimits reads a -rate-limits file. Routes it doesn't mention, and
+// that its default doesn't cover, have no limit.
+func loadRateLimits(path string) ([]limitedRoute, rateLimit, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, rateLimit{}, fmt.Errorf("reading rate limits: %w", err)
+	}
+	var file rateLimitsFile
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, rateLimit{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var fallback rateLimit
+	if file.Default != nil {
+		if file.Default.Route != "" {
+			return nil, rateLimit{}, fmt.Errorf("%s: the default limit has no route", path)
+		}
+		if fallback, err = file.Default.parse(); err != nil {
+			return nil, rateLimit{}, fmt.Errorf("%s: default: %w", path, err)
+		}
+	}
+	var routes []limitedRoute
+	for _, r := range file.Routes {
+		rule, err := parseRouteRule(r.Route)
+		if err != nil {
+			return nil, rateLimit{}, fmt.Errorf("%s: %w", path, err)
+		}
+		limit, err := r.parse()
+		if err != nil {
+			return nil, rateLimit{}, fmt.Errorf("%s: %s: %w", path, r.Route, err)
+		}
+		routes = append(routes, limitedRoute{rule: rule, limit: limit})
+	}
+	return routes, fallback, nil
+}
+
+// checkRoutes makes sure every rule naming a single route names a real one.
+func (l *rateLimiter) checkRoutes(router *mux.Router) error {
+	rules := make([]routeRule, len(l.routes))
+	for i, r := range l.routes {
+		rules[i] = r.rule
+	}
+	return checkRouteRules(router, rules)
+}
+
+// middleware answers 429 to clients that have used up their bucket for the
+// route. Every limited response says where the client stands:
+//
+//	X-RateLimit-Limit      the burst
+//	X-RateLimit-Remaining  requests left right now
+//	X-RateLimit-Reset      seconds until the bucket is full again
+//	Retry-After            seconds until the next request is allowed (429 only)
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, _ := mux.CurrentRoute(r).GetPathTemplate()
+		key := bucketKey{route: len(l.routes), client: l.client(r)}
+		limit := l.fallback
+		for i, lr := range l.routes {
+			if lr.rule.matches(r.Method, route) {
+				key.route, limit = i, lr.limit
+				break
+			}
+		}
+		if limit.rate == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed, remaining, retry, reset := l.take(key, limit, time.Now())
+		h := w.Header()
+		h.Set("X-RateLimit-Limit", strconv.Itoa(limit.burst))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		h.Set("X-RateLimit-Reset", strconv.Itoa(seconds(reset)))
+		if !allowed {
+			h.Set("Retry-After", strconv.Itoa(seconds(retry)))
+			writeError(w, http.StatusTooManyRequests, codeTooManyRequests,
+				fmt.Sprintf("too many requests, retry in %ds", seconds(retry)))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// take takes a token from the bucket of key at time now, if there is one.
+// It returns how many whole tokens are left, how long until there is a
+// token again and how long until the bucket is full.
+func (l *rateLimiter) take(key bucketKey, limit rateLimit, now time.Time) (allowed bool, remaining int, retry, reset time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.burst), b.tokens+now.Sub(b.last).Seconds()*limit.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		allowed = true
+	} else {
+		retry = secondsOf((1 - b.tokens) / limit.rate)
+	}
+	reset = secondsOf((float64(limit.burst) - b.tokens) / limit.rate)
+	return allowed, int(b.tokens), retry, reset
+}
+
+// sweep drops the buckets that are full again by now.
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		limit := l.fallback
+		if key.route < len(l.routes) {
+			limit = l.routes[key.route].limit
+		}
+		if b.tokens+now.Sub(b.last).Seconds()*limit.rate >= float64(limit.burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+func secondsOf(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// seconds rounds d up to whole seconds, as the rate limit headers want.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// clientIP returns the address r came from, without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimitKey names the client a request counts against: the user it was
+// authenticated as (by API key or token), or else its IP address.
+func rateLimitKey(r *http.Request) string {
+	if id, ok := identityOf(r); ok {
+		return "user:" + id.Name
+	}
+	return "ip:" + clientIP(r)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingAuthenticator counts the requests it checks.
+type countingAuthenticator struct {
+	Authenticator
+	calls atomic.Int64
+}
+
+func (a *countingAuthenticator) Authenticate(r *http.Request) (Identity, bool, error) {
+	a.calls.Add(1)
+	return a.Authenticator.Authenticate(r)
+}
+
+// Every user has buckets of their own, requests with bad credentials count
+// against their IP address, and credentials are checked once per request.
+func TestRateLimitPerUser(t *testing.T) {
+	dir := t.TempDir()
+	keys := writeFile(t, dir, "keys", "alice "+testAPIKey+"\nbob "+testAPIKey+"bob\n")
+	policy, err := authConfig{APIKeysFile: keys}.policy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter := &countingAuthenticator{Authenticator: policy.authenticators[0]}
+	policy.authenticators[0] = counter
+
+	routes, fallback, err := loadRateLimits(writeFile(t, dir, "limits.json",
+		`{"routes": [{"route": "GET /movies", "rate": "1/h", "burst": 2}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestServer(t)
+	srv.auth = policy
+	srv.limit = newRateLimiter(routes, fallback, rateLimitKey)
+	h := srv.routes()
+
+	tests := []struct {
+		who, target string
+		want        int
+	}{
+		{"alice", "/movies", http.StatusOK},
+		{"alice", "/movies", http.StatusOK},
+		{"alice", "/movies", http.StatusTooManyRequests},
+		{"bob", "/movies", http.StatusOK},
+		{"mallory", "/movies", http.StatusUnauthorized},
+		{"mallory", "/movies", http.StatusUnauthorized},
+		{"mallory", "/movies", http.StatusTooManyRequests},
+		// routes the file doesn't mention, without a default, have no limit
+		{"alice", "/directors", http.StatusOK},
+	}
+	for i, tt := range tests {
+		key := testAPIKey
+		if tt.who != "alice" {
+			key += tt.who
+		}
+		resp := do(t, h, "GET", tt.target, "", "X-API-Key", key)
+		if resp.StatusCode != tt.want {
+			t.Errorf("%d: %s GET %s: status %d, want %d", i, tt.who, tt.target, resp.StatusCode, tt.want)
+		}
+		if got := counter.calls.Load(); got != int64(i+1) {
+			t.Fatalf("%d: credentials checked %d times over %d requests", i, got, i+1)
+		}
+	}
+	if resp := do(t, h, "GET", "/directors", "", "X-API-Key", testAPIKey); resp.Header.Get("X-RateLimit-Limit") != "" {
+		t.Errorf("an unlimited route has X-RateLimit-Limit %q", resp.Header.Get("X-RateLimit-Limit"))
+	}
+}
+
+// A bucket fills up again at the rate of its limit. Every sweepInterval the
+// buckets that are full again are dropped, and the others kept.
+func TestRateLimiterSweep(t *testing.T) {
+	fast := rateLimit{rate: 1, burst: 2}
+	slow := rateLimit{rate: 1.0 / 3600, burst: 2}
+	l := newRateLimiter([]limitedRoute{{limit: fast}}, slow, nil)
+	fastKey, slowKey := bucketKey{0, "alice"}, bucketKey{1, "alice"}
+	now := time.Now()
+
+	l.take(fastKey, fast, now)
+	l.take(fastKey, fast, now)
+	if allowed, remaining, retry, reset := l.take(fastKey, fast, now); allowed || remaining != 0 || retry != time.Second || reset != 2*time.Second {
+		t.Fatalf("third request at once: allowed %v, remaining %d, retry %v, reset %v; want refused, 0, 1s, 2s",
+			allowed, remaining, retry, reset)
+	}
+	if allowed, _, _, _ := l.take(fastKey, fast, now.Add(time.Second)); !allowed {
+		t.Fatal("a second later: refused")
+	}
+
+	l.take(fastKey, fast, now.Add(sweepInterval+time.Second))
+	l.take(slowKey, slow, now.Add(sweepInterval+time.Second))
+	// the next sweep finds the fast bucket full again, the slow one not
+	l.take(bucketKey{0, "bob"}, fast, now.Add(2*sweepInterval+time.Second))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.buckets[fastKey]; ok {
+		t.Error("a full bucket was not dropped")
+	}
+	if _, ok := l.buckets[slowKey]; !ok {
+		t.Error("a partly drained bucket was dropped")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// rateMovie handles POST /movies/{id}/ratings. Each user has at most one
+// rating per movie: rating again replaces the previous score.
+//
+//	{"user": "alice", "score": 4}
+//
+// An authenticated caller rates as themselves: user can be left out, and
+// naming anybody else is refused.
+func (s *server) rateMovie(w http.ResponseWriter, r *http.Request) {
+	var rating Rating
+	unknown, ok := decodeJSON(w, r, &rating)
+	if !ok {
+		return
+	}
+	if id, ok := identityOf(r); ok {
+		if rating.User != "" && rating.User != id.Name {
+			unknown = append(unknown, fieldError{Field: "user", Message: "must be left out or be your own name"})
+		}
+		rating.User = id.Name
+	}
+	if errs := append(unknown, rating.validate()...); errs != nil {
+		writeValidationError(w, errs)
+		return
+	}
+
+	params := mux.Vars(r)
+	for attempt := 0; ; attempt++ {
+		movie, err := s.getLive(params["id"])
+		if errors.Is(err, ErrMovieNotFound) {
+			writeError(w, http.StatusNotFound, codeNotFound, "movie not found")
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		replaced := false
+		for i := range movie.Ratings {
+			if movie.Ratings[i].User == rating.User {
+				movie.Ratings[i] = rating
+				replaced = true
+			}
+		}
+		if !replaced {
+			if len(movie.Ratings) >= maxRatings {
+				writeValidationError(w, []fieldError{{Field: "ratings", Message: "this movie has too many ratings"}})
+				return
+			}
+			movie.Ratings = append(movie.Ratings, rating)
+		}
+
+		// movie.Version is still the one we read, so a rating added by
+		// someone else in the meantime isn't lost
+		movie, err = s.change(r, func(tx *server) (MovieEvent, error) {
+			updated, err := tx.store.Update(movie.ID, movie)
+			return MovieEvent{Action: actionUpdate, Movie: updated}, err
+		})
+		if errors.Is(err, ErrVersionMismatch) && attempt < maxConflictRetries {
+			continue
+		}
+		if errors.Is(err, ErrVersionMismatch) {
+			writeBusy(w)
+			return
+		}
+		if errors.Is(err, ErrMovieNotFound) {
+			writeError(w, http.StatusNotFound, codeNotFound, "movie not found")
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		writeMovie(w, http.StatusOK, movie)
+		return
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// Authenticated callers rate as themselves; anonymous ones name the user.
+func TestRatingUser(t *testing.T) {
+	rate := func(h http.Handler, body string, want int, header ...string) Movie {
+		t.Helper()
+		resp := do(t, h, "POST", "/movies/1/ratings", body, header...)
+		if resp.StatusCode != want {
+			t.Fatalf("rating %s: status %d, want %d", body, resp.StatusCode, want)
+		}
+		var movie Movie
+		if want == http.StatusOK {
+			decodeBody(t, resp, &movie)
+		}
+		return movie
+	}
+
+	var h http.Handler = newTestServer(t).routes()
+	rate(h, `{"score":4}`, http.StatusUnprocessableEntity)
+	if movie := rate(h, `{"user":"bob","score":4}`, http.StatusOK); len(movie.Ratings) != 1 || movie.Ratings[0].User != "bob" {
+		t.Errorf("ratings = %+v, want bob's", movie.Ratings)
+	}
+
+	keys := writeFile(t, t.TempDir(), "keys", "alice "+testAPIKey+"\n")
+	h = newAuthServer(t, authConfig{APIKeysFile: keys})
+	rate(h, `{"user":"bob","score":1}`, http.StatusUnprocessableEntity, "X-API-Key", testAPIKey)
+	movie := rate(h, `{"score":5}`, http.StatusOK, "X-API-Key", testAPIKey)
+	if len(movie.Ratings) != 1 || movie.Ratings[0] != (Rating{User: "alice", Score: 5}) {
+		t.Errorf("ratings = %+v, want alice's 5", movie.Ratings)
+	}
+	movie = rate(h, `{"user":"alice","score":3}`, http.StatusOK, "X-API-Key", testAPIKey)
+	if len(movie.Ratings) != 1 || movie.Ratings[0].Score != 3 {
+		t.Errorf("ratings = %+v, want alice's 3 instead of 5", movie.Ratings)
+	}
+}
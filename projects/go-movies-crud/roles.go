@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// rolesFile is the layout of the -roles file:
+//
+//	{
+//	  "roles": {
+//	    "viewer": ["GET *"],
+//	    "editor": ["GET *", "POST /movies", "PUT /movies/{id}"],
+//	    "admin":  ["* *"]
+//	  },
+//	  "users": {"alice": "admin", "ci-bot": "editor"},
+//	  "defaultRole": "viewer"
+//	}
+//
+// A rule is a method (or *) and a route as it is registered in routes()
+// (or a prefix of one ending in *). Users are the names requests are
+// authenticated as; anyone else, including public reads made without
+// credentials, gets defaultRole, if there is one.
+type rolesFile struct {
+	Roles       map[string][]string `json:"roles"`
+	Users       map[string]string   `json:"users"`
+	DefaultRole string              `json:"defaultRole"`
+}
+
+// routeRule picks requests by method and route, like "GET /movies/{id}",
+// "POST *" or "* /directors*". Roles and rate limits are made of them.
+type routeRule struct {
+	method string // "*" for any
+	route  string // a route template, or a prefix of some when prefix is set
+	prefix bool
+}
+
+var ruleMethods = map[string]bool{"*": true, "GET": true, "POST": true, "PUT": true, "PATCH": true, "DELETE": true}
+
+func parseRouteRule(rule string) (routeRule, error) {
+	method, route, ok := strings.Cut(rule, " ")
+	route = strings.TrimSpace(route)
+	if !ok || !ruleMethods[method] || route == "" {
+		return routeRule{}, fmt.Errorf("rule %q must be a method (or *) and a route, like \"GET /movies\"", rule)
+	}
+	if strings.HasSuffix(route, "*") {
+		return routeRule{method: method, route: strings.TrimSuffix(route, "*"), prefix: true}, nil
+	}
+	return routeRule{method: method, route: route}, nil
+}
+
+func (rule routeRule) matches(method, route string) bool {
+	if rule.method != "*" && rule.method != method {
+		return false
+	}
+	if rule.prefix {
+		return strings.HasPrefix(route, rule.route)
+	}
+	return rule.route == route
+}
+
+// checkRouteRules makes sure every rule naming a single route names one of
+// router's, so a typo can't quietly miss the route it was meant for.
+func checkRouteRules(router *mux.Router, rules []routeRule) error {
+	known := map[string]bool{}
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tmpl, err := route.GetPathTemplate()
+		known[tmpl] = err == nil
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if !rule.prefix && !known[rule.route] {
+			return fmt.Errorf("no route %s", rule.route)
+		}
+	}
+	return nil
+}
+
+// rolePolicy is the authorization middleware: it decides what each user,
+// and anonymous requests, may do.
+type rolePolicy struct {
+	roles       map[string][]routeRule
+	users       map[string]string
+	defaultRole string
+}
+
+// loadRoles reads and checks a roles file.
+func loadRoles(path string) (*rolePolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading roles: %w", err)
+	}
+	var file rolesFile
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	p := &rolePolicy{roles: map[string][]routeRule{}, users: file.Users, defaultRole: file.DefaultRole}
+	for role, rules := range file.Roles {
+		p.roles[role] = []routeRule{}
+		for _, rule := range rules {
+			parsed, err := parseRouteRule(rule)
+			if err != nil {
+				return nil, fmt.Errorf("%s: role %q: %w", path, role, err)
+			}
+			p.roles[role] = append(p.roles[role], parsed)
+		}
+	}
+	for user, role := range p.users {
+		if _, ok := p.roles[role]; !ok {
+			return nil, fmt.Errorf("%s: user %q has unknown role %q", path, user, role)
+		}
+	}
+	if _, ok := p.roles[p.defaultRole]; p.defaultRole != "" && !ok {
+		return nil, fmt.Errorf("%s: unknown default role %q", path, p.defaultRole)
+	}
+	return p, nil
+}
+
+// checkRoutes makes sure the rules of every role name real routes, so a
+// typo can't quietly lock everybody out of one.
+func (p *rolePolicy) checkRoutes(router *mux.Router) error {
+	names := make([]string, 0, len(p.roles))
+	for role := range p.roles {
+		names = append(names, role)
+	}
+	sort.Strings(names)
+	for _, role := range names {
+		if err := checkRouteRules(router, p.roles[role]); err != nil {
+			return fmt.Errorf("role %q: %w", role, err)
+		}
+	}
+	return nil
+}
+
+// authorize returns an error unless id may use method on route. The zero
+// Identity stands for an anonymous request.
+func (p *rolePolicy) authorize(id Identity, method, route string) error {
+	role, ok := p.users[id.Name]
+	if !ok || id == (Identity{}) {
+		role = p.defaultRole
+	}
+	if role == "" {
+		name := id.Name
+		if id == (Identity{}) {
+			name = "anonymous"
+		}
+		return fmt.Errorf("%s has no role", name)
+	}
+	for _, rule := range p.roles[role] {
+		if rule.matches(method, route) {
+			return nil
+		}
+	}
+	return fmt.Errorf("role %s may not %s %s", role, method, route)
+}
+
+// middleware refuses requests their user's role doesn't allow with a 403.
+// Requests without an identity got through authentication as public reads
+// and are checked against the default role.
+func (p *rolePolicy) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := identityOf(r)
+		route, _ := mux.CurrentRoute(r).GetPathTemplate()
+		if err := p.authorize(id, r.Method, route); err != nil {
+			writeError(w, http.StatusForbidden, codeForbidden, err.Error())
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
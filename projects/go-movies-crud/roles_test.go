@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRoles(t *testing.T) {
+	dir := t.TempDir()
+	keys := writeFile(t, dir, "keys", "alice "+testAPIKey+"\nbob "+testAPIKey+"bob\ncarol "+testAPIKey+"carol\n")
+	newRoleServer := func(roles string) http.Handler {
+		t.Helper()
+		policy, err := authConfig{APIKeysFile: keys, PublicReads: true}.policy()
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := newTestServer(t)
+		srv.auth = policy
+		if srv.roles, err = loadRoles(writeFile(t, dir, "roles.json", roles)); err != nil {
+			t.Fatal(err)
+		}
+		h := srv.routes()
+		if err := srv.roles.checkRoutes(h); err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	h := newRoleServer(`{
+		"roles": {
+			"reader": ["GET /movies", "GET /movies/{id}"],
+			"editor": ["GET *", "POST /movies", "PUT /movies/{id}"],
+			"admin": ["* *"]
+		},
+		"users": {"alice": "admin", "bob": "editor"},
+		"defaultRole": "reader"
+	}`)
+	tests := []struct {
+		who, method, target, body string
+		want                      int
+	}{
+		{"alice", "DELETE", "/movies/4", "", http.StatusNoContent},
+		{"bob", "PUT", "/movies/1", `{"isbn":"9780345341464","title":"Star Wars","directorId":"1"}`, http.StatusOK},
+		{"bob", "DELETE", "/movies/1", "", http.StatusForbidden},
+		{"bob", "GET", "/directors", "", http.StatusOK},
+		{"carol", "GET", "/movies/1", "", http.StatusOK}, // not in users: the default role
+		{"carol", "GET", "/directors", "", http.StatusForbidden},
+		// anonymous public reads get the default role too
+		{"", "GET", "/movies/1", "", http.StatusOK},
+		{"", "GET", "/directors", "", http.StatusForbidden},
+		{"", "GET", "/movies/1/history", "", http.StatusForbidden},
+		// a batch needs the permission of every operation in it
+		{"bob", "POST", "/movies:batch", `{"operations":[{"op":"delete","id":"2"}]}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		var header []string
+		switch tt.who {
+		case "alice":
+			header = []string{"X-API-Key", testAPIKey}
+		case "bob", "carol":
+			header = []string{"X-API-Key", testAPIKey + tt.who}
+		}
+		if resp := do(t, h, tt.method, tt.target, tt.body, header...); resp.StatusCode != tt.want {
+			t.Errorf("%q %s %s: status %d, want %d", tt.who, tt.method, tt.target, resp.StatusCode, tt.want)
+		}
+	}
+
+	// without a default role, anonymous requests get nowhere
+	h = newRoleServer(`{"roles": {"admin": ["* *"]}, "users": {"alice": "admin"}}`)
+	if resp := do(t, h, "GET", "/movies", ""); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("anonymous GET without a default role: status %d, want 403", resp.StatusCode)
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// How much a match in each field is worth. A hit in the title matters more
+// than one in the director's name, which matters more than the ISBN.
+const (
+	weightTitle    = 3.0
+	weightDirector = 2.0
+	weightISBN     = 1.0
+)
+
+// How good a match is: the exact word, the start of a word, or a word with
+// a typo or two in it.
+const (
+	qualityExact  = 1.0
+	qualityPrefix = 0.7
+	qualityTypo   = 0.5
+)
+
+// searchIndex is an in-memory inverted index: for every word it knows which
+// movies contain it and how much it is worth in each.
+type searchIndex struct {
+	// writes serializes the store writes made through an indexedStore with
+	// the index updates that follow them, so the index ends up with the last
+	// version of a movie even when two requests change it at once
+	writes sync.Mutex
+
+	mu       sync.RWMutex
+	postings map[string]map[string]float64 // term -> movie ID -> weight
+	terms    map[string][]string           // movie ID -> its terms, for removal
+}
+
+// newSearchIndex builds an index of every movie currently in store.
+func newSearchIndex(store MovieStore) (*searchIndex, error) {
+	idx := &searchIndex{
+		postings: map[string]map[string]float64{},
+		terms:    map[string][]string{},
+	}
+	movies, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, movie := range movies {
+		if movie.DeletedAt == nil {
+			idx.put(movie)
+		}
+	}
+	return idx, nil
+}
+
+// size returns how many movies are indexed.
+func (idx *searchIndex) size() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.terms)
+}
+
+// put (re)indexes a movie. A word is worth the weight of the best field it
+// appears in: repeating it, as in "The Return of the King", doesn't make
+// the movie a better match.
+func (idx *searchIndex) put(movie Movie) {
+	weights := map[string]float64{}
+	add := func(text string, weight float64) {
+		for _, term := range tokenize(text) {
+			weights[term] = max(weights[term], weight)
+		}
+	}
+	add(movie.Title, weightTitle)
+	if movie.Director != nil {
+		add(movie.Director.FirstName, weightDirector)
+		add(movie.Director.LastName, weightDirector)
+	}
+	// index the ISBN both in pieces and with the hyphens stripped
+	add(movie.ISBN, weightISBN)
+	add(strings.NewReplacer("-", "", " ", "").Replace(movie.ISBN), weightISBN)
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(movie.ID)
+	for term, weight := range weights {
+		if idx.postings[term] == nil {
+			idx.postings[term] = map[string]float64{}
+		}
+		idx.postings[term][movie.ID] = weight
+		idx.terms[movie.ID] = append(idx.terms[movie.ID], term)
+	}
+}
+
+// remove drops a movie from the index.
+func (idx *searchIndex) remove(id string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(id)
+}
+
+func (idx *searchIndex) removeLocked(id string) {
+	for _, term := range idx.terms[id] {
+		delete(idx.postings[term], id)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	delete(idx.terms, id)
+}
+
+// searchResult is a movie ID with its relevance score.
+type searchResult struct {
+	id    string
+	score float64
+}
+
+// search returns the IDs of the movies matching query, best match first.
+// Every query word is compared with every indexed word, which is fine for a
+// catalog of a few thousand movies.
+func (idx *searchIndex) search(query string) []searchResult {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	scores := map[string]float64{}
+	for _, word := range tokenize(query) {
+		// a query word counts once per movie, with its best match
+		best := map[string]float64{}
+		for term, docs := range idx.postings {
+			quality := matchQuality(word, term)
+			if quality == 0 {
+				continue
+			}
+			for id, weight := range docs {
+				if s := quality * weight; s > best[id] {
+					best[id] = s
+				}
+			}
+		}
+		for id, s := range best {
+			scores[id] += s
+		}
+	}
+
+	results := make([]searchResult, 0, len(scores))
+	for id, score := range scores {
+		results = append(results, searchResult{id: id, score: score})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return results[i].id < results[j].id
+	})
+	return results
+}
+
+// matchQuality says how well a query word matches an indexed term, or 0 if
+// it doesn't match at all.
+func matchQuality(word, term string) float64 {
+	switch {
+	case word == term:
+		return qualityExact
+	case len(word) >= 2 && strings.HasPrefix(term, word):
+		return qualityPrefix
+	}
+	if d := maxTypos(word); d > 0 && editDistance(word, term, d) <= d {
+		return qualityTypo
+	}
+	return 0
+}
+
+// maxTypos is how many edits a word of this length may have and still
+// match: none for short words, where a typo changes the meaning.
+func maxTypos(word string) int {
+	switch n := len([]rune(word)); {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	}
+	return 0
+}
+
+// editDistance returns the optimal string alignment distance between a and
+// b: the Levenshtein distance, with swapping two adjacent letters ("matirx")
+// counting as a single edit. It returns limit+1 as soon as it is clear the
+// distance is larger than limit.
+func editDistance(a, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+	if diff := len(ra) - len(rb); diff > limit || -diff > limit {
+		return limit + 1
+	}
+
+	// three rows of the distance matrix: a transposition looks two back
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	prevMin := 0
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, curr[j])
+		}
+		// the next row builds on this one and, for a transposition, on
+		// the one before it: once both are over the limit, so is the rest
+		if rowMin > limit && prevMin > limit {
+			return limit + 1
+		}
+		prevMin = rowMin
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return prev[len(rb)]
+}
+
+// tokenize lower-cases text and splits it into words of letters and digits.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// indexedStore wraps a Store and keeps a search index up to date with
+// every change that goes through it.
+type indexedStore struct {
+	Store
+	index *searchIndex
+}
+
+func (s indexedStore) Create(movie Movie) (Movie, error) {
+	s.index.writes.Lock()
+	defer s.index.writes.Unlock()
+	created, err := s.Store.Create(movie)
+	if err == nil {
+		s.index.put(created)
+	}
+	return created, err
+}
+
+func (s indexedStore) Update(id string, movie Movie) (Movie, error) {
+	s.index.writes.Lock()
+	defer s.index.writes.Unlock()
+	updated, err := s.Store.Update(id, movie)
+	if err == nil {
+		s.index.put(updated)
+	}
+	return updated, err
+}
+
+func (s indexedStore) Delete(id string, version int) error {
+	s.index.writes.Lock()
+	defer s.index.writes.Unlock()
+	err := s.Store.Delete(id, version)
+	if err == nil {
+		s.index.remove(id)
+	}
+	return err
+}
+
+func (s indexedStore) Restore(id string) (Movie, error) {
+	s.index.writes.Lock()
+	defer s.index.writes.Unlock()
+	restored, err := s.Store.Restore(id)
+	if err == nil {
+		s.index.put(restored)
+	}
+	return restored, err
+}
+
+// Atomically indexes the changes fn makes once they are committed, and not
+// at all if they are rolled back.
+func (s indexedStore) Atomically(fn func(Store) error) error {
+	s.index.writes.Lock()
+	defer s.index.writes.Unlock()
+	changed := map[string]bool{}
+	err := s.Store.Atomically(func(tx Store) error {
+		return fn(changeTracker{Store: tx, changed: changed})
+	})
+	if err != nil {
+		return err
+	}
+	for id := range changed {
+		movie, err := s.Store.Get(id)
+		if err == nil && movie.DeletedAt == nil {
+			s.index.put(movie)
+		} else {
+			s.index.remove(id)
+		}
+	}
+	return nil
+}
+
+// UpdateDirector reindexes the director's movies, since their director's
+// name is searchable.
+func (s indexedStore) UpdateDirector(id string, director Director) (Director, error) {
+	s.index.writes.Lock()
+	defer s.index.writes.Unlock()
+	updated, err := s.Store.UpdateDirector(id, director)
+	if err != nil {
+		return updated, err
+	}
+	movies, err := moviesOf(s.Store, id)
+	for _, movie := range movies {
+		s.index.put(movie)
+	}
+	return updated, err
+}
+
+// DeleteDirector drops the director's movies from the index when the delete
+// cascades.
+func (s indexedStore) DeleteDirector(id string, cascade bool) error {
+	s.index.writes.Lock()
+	defer s.index.writes.Unlock()
+	movies, err := moviesOf(s.Store, id)
+	if err != nil {
+		return err
+	}
+	if err := s.Store.DeleteDirector(id, cascade); err != nil {
+		return err
+	}
+	for _, movie := range movies {
+		s.index.remove(movie.ID)
+	}
+	return nil
+}
+
+// changeTracker wraps the Store of a transaction and notes the IDs of the
+// movies changed through it, for indexedStore.Atomically.
+type changeTracker struct {
+	Store
+	changed map[string]bool
+}
+
+func (t changeTracker) Create(movie Movie) (Movie, error) {
+	created, err := t.Store.Create(movie)
+	if err == nil {
+		t.changed[created.ID] = true
+	}
+	return created, err
+}
+
+func (t changeTracker) Update(id string, movie Movie) (Movie, error) {
+	t.changed[id] = true
+	return t.Store.Update(id, movie)
+}
+
+func (t changeTracker) Delete(id string, version int) error {
+	t.changed[id] = true
+	return t.Store.Delete(id, version)
+}
+
+func (t changeTracker) Restore(id string) (Movie, error) {
+	t.changed[id] = true
+	return t.Store.Restore(id)
+}
+
+func (t changeTracker) UpdateDirector(id string, director Director) (Director, error) {
+	t.markMoviesOf(id)
+	return t.Store.UpdateDirector(id, director)
+}
+
+func (t changeTracker) DeleteDirector(id string, cascade bool) error {
+	t.markMoviesOf(id)
+	return t.Store.DeleteDirector(id, cascade)
+}
+
+func (t changeTracker) markMoviesOf(directorID string) {
+	movies, _ := t.Store.List()
+	for _, movie := range movies {
+		if movie.DirectorID == directorID {
+			t.changed[movie.ID] = true
+		}
+	}
+}
+
+// searchMovies handles GET /movies/search?q=...&limit=...
+func (s *server) searchMovies(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if strings.TrimSpace(query) == "" {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "query parameter q is required")
+		return
+	}
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxLimit {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "limit must be a number between 1 and "+strconv.Itoa(maxLimit))
+			return
+		}
+		limit = n
+	}
+
+	results := s.index.search(query)
+	movies := []Movie{}
+	for _, result := range results {
+		if len(movies) == limit {
+			break
+		}
+		movie, err := s.getLive(result.id)
+		if errors.Is(err, ErrMovieNotFound) {
+			continue // deleted since the search
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		movies = append(movies, movie)
+	}
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(results)))
+	writeJSON(w, http.StatusOK, movies)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestSearchRanking(t *testing.T) {
+	store := newMemoryStore(catalog{
+		Directors: []Director{{ID: "1", FirstName: "Peter", LastName: "Jackson"}, {ID: "2", FirstName: "Lana", LastName: "Wachowski"}},
+		Movies: []Movie{
+			{ID: "1", Title: "The Lord of the Rings: The Return of the King", DirectorID: "1"},
+			{ID: "2", Title: "The Matrix", DirectorID: "2"},
+		},
+	})
+	index, err := newSearchIndex(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		want  string // ID of the best match
+	}{
+		{"the matrix", "2"},
+		{"matirx", "2"},   // transposed letters
+		{"matrx", "2"},    // missing letter
+		{"the king", "1"}, // repeated "the" doesn't count twice
+		{"jackson", "1"},
+	}
+	for _, tt := range tests {
+		results := index.search(tt.query)
+		if len(results) == 0 || results[0].id != tt.want {
+			t.Errorf("search(%q) = %v, want %s first", tt.query, results, tt.want)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"matrix", "matrix", 0},
+		{"matirx", "matrix", 1},
+		{"matrx", "matrix", 1},
+		{"natrix", "matrix", 1},
+		{"amtirx", "matrix", 2},
+		{"inception", "matrix", 3}, // over the limit
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b, 2); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// Concurrent updates of one movie must leave the index with the version
+// that ended up in the store.
+func TestIndexFollowsConcurrentUpdates(t *testing.T) {
+	srv := newTestServer(t)
+	h := srv.routes()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 16; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				title := fmt.Sprintf("Title%d x%d", w, i)
+				do(t, h, "PUT", "/movies/1", `{"isbn":"9780345341464","title":"`+title+`","directorId":"1"}`).Body.Close()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	stored, err := srv.store.Get("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a fresh index of the stored movie has the terms the live one should
+	want := &searchIndex{postings: map[string]map[string]float64{}, terms: map[string][]string{}}
+	want.put(stored)
+	got := srv.index.terms["1"]
+	sort.Strings(got)
+	sort.Strings(want.terms["1"])
+	if !reflect.DeepEqual(got, want.terms["1"]) {
+		t.Errorf("movie 1 is indexed as %q, want %q (%q)", got, want.terms["1"], stored.Title)
+	}
+}
+
+func TestSearchMovies(t *testing.T) {
+	h := newTestServer(t).routes()
+	for _, title := range []string{"Alien", "Aliens", "Alien Resurrection"} {
+		resp := do(t, h, "POST", "/movies", `{"isbn":"9780345341464","title":"`+title+`","directorId":"1"}`)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("POST %s: status %d", title, resp.StatusCode)
+		}
+	}
+
+	for _, query := range []string{"", "q=", "q=+", "q=alien&limit=0", "q=alien&limit=1001", "q=alien&limit=two"} {
+		resp := do(t, h, "GET", "/movies/search?"+query, "")
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("GET /movies/search?%s: status %d, want 400", query, resp.StatusCode)
+		}
+	}
+
+	search := func(query string, wantTotal int, wantTitles ...string) []Movie {
+		t.Helper()
+		resp := do(t, h, "GET", "/movies/search?"+query, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET /movies/search?%s: status %d", query, resp.StatusCode)
+		}
+		if got := resp.Header.Get("X-Total-Count"); got != strconv.Itoa(wantTotal) {
+			t.Errorf("GET /movies/search?%s: X-Total-Count %s, want %d", query, got, wantTotal)
+		}
+		var movies []Movie
+		decodeBody(t, resp, &movies)
+		var titles []string
+		for _, m := range movies {
+			titles = append(titles, m.Title)
+		}
+		sort.Strings(titles)
+		if !reflect.DeepEqual(titles, wantTitles) {
+			t.Errorf("GET /movies/search?%s = %q, want %q", query, titles, wantTitles)
+		}
+		return movies
+	}
+	search("q=alien", 3, "Alien", "Alien Resurrection", "Aliens")
+	search("q=nothing+like+it", 0)
+	// the exact matches rank above "aliens"
+	movies := search("q=alien&limit=2", 3, "Alien", "Alien Resurrection")
+
+	// deleted movies aren't found
+	for _, m := range movies {
+		do(t, h, "DELETE", "/movies/"+m.ID, "").Body.Close()
+	}
+	search("q=alien", 1, "Aliens")
+}
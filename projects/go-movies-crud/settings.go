@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+)
+
+// settings are everything the server can be configured with. Each one is a
+// flag, and can also be set in the environment or the config file (see the
+// go-config module).
+type settings struct {
+	addr              string
+	readHeaderTimeout time.Duration
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	shutdownTimeout   time.Duration
+	shutdownDelay     time.Duration
+	logFormat         string
+	logLevel          string
+
+	dataFile  string
+	dbFile    string
+	seedFile  string
+	idKind    string
+	retention time.Duration
+	metrics   bool
+
+	auth           authConfig
+	rolesFile      string
+	rateLimitsFile string
+}
+
+// secretSettings are refused on the command line, and left out when the
+// config is printed.
+var secretSettings = []string{"jwt-hs256-secret"}
+
+func (st *settings) register(fs *flag.FlagSet) {
+	fs.StringVar(&st.addr, "addr", ":8000", "address to listen on")
+	fs.DurationVar(&st.readHeaderTimeout, "read-header-timeout", 5*time.Second, "how long a client may take to send the request headers")
+	fs.DurationVar(&st.readTimeout, "read-timeout", 30*time.Second, "how long a client may take to send a whole request")
+	fs.DurationVar(&st.writeTimeout, "write-timeout", 60*time.Second, "how long writing a response may take (exports are exempt)")
+	fs.DurationVar(&st.idleTimeout, "idle-timeout", 2*time.Minute, "how long an idle keep-alive connection is kept open")
+	fs.DurationVar(&st.shutdownTimeout, "shutdown-timeout", 30*time.Second, "how long to wait for requests in progress when shutting down")
+	fs.DurationVar(&st.shutdownDelay, "shutdown-delay", 0, "how long to keep serving, with /readyz failing, before shutting down")
+	fs.StringVar(&st.logFormat, "log-format", "text", "log format: text or json")
+	fs.StringVar(&st.logLevel, "log-level", "info", "lowest level logged: debug, info, warn or error")
+
+	fs.StringVar(&st.dataFile, "data", "movies.json", "JSON file to keep the movies in (empty = memory only)")
+	fs.StringVar(&st.dbFile, "db", "", "SQLite database to keep the movies in (overrides -data)")
+	fs.StringVar(&st.seedFile, "seed", "", "JSON catalog a new store starts with (empty = the sample movies)")
+	fs.StringVar(&st.idKind, "ids", "uuid", "how to generate movie and director IDs: uuid, ulid or seq")
+	fs.DurationVar(&st.retention, "retention", 30*24*time.Hour, "how long deleted movies can be restored before they are purged (0 = forever)")
+	fs.BoolVar(&st.metrics, "metrics", true, "serve Prometheus metrics at /metrics")
+
+	fs.StringVar(&st.auth.APIKeysFile, "api-keys", "", "file of API keys, one \"name key\" pair per line")
+	fs.StringVar(&st.auth.HS256KeyFile, "jwt-hs256-key", "", "file holding the secret of HS256 tokens")
+	fs.StringVar(&st.auth.HS256Secret, "jwt-hs256-secret", "", "the secret of HS256 tokens itself, instead of -jwt-hs256-key (environment or config file only)")
+	fs.StringVar(&st.auth.RS256KeyFile, "jwt-rs256-key", "", "PEM file holding the public key of RS256 tokens")
+	fs.StringVar(&st.auth.Issuer, "jwt-issuer", "", "required \"iss\" of tokens")
+	fs.StringVar(&st.auth.Audience, "jwt-audience", "", "required \"aud\" of tokens")
+	fs.BoolVar(&st.auth.PublicReads, "public-reads", true, "let GET requests through without credentials")
+	fs.StringVar(&st.rolesFile, "roles", "", "JSON file of roles and the users that have them (empty = authenticated users may do anything)")
+	fs.StringVar(&st.rateLimitsFile, "rate-limits", "", "JSON file of per-route rate limits (empty = no rate limiting)")
+}
+
+// validate reports every setting that is out of range or at odds with
+// another one. The files the settings name are checked when they are read.
+func (st *settings) validate() error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if _, port, err := net.SplitHostPort(st.addr); err != nil {
+		add("addr: %q must look like :8000 or 127.0.0.1:8000", st.addr)
+	} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		add("addr: %q is not a valid port", port)
+	}
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"read-header-timeout", st.readHeaderTimeout},
+		{"read-timeout", st.readTimeout},
+		{"write-timeout", st.writeTimeout},
+		{"idle-timeout", st.idleTimeout},
+		{"shutdown-timeout", st.shutdownTimeout},
+	} {
+		if d.value <= 0 {
+			add("%s: must be more than 0", d.name)
+		}
+	}
+	if st.shutdownDelay < 0 {
+		add("shutdown-delay: must not be negative")
+	}
+	if st.readHeaderTimeout > st.readTimeout {
+		add("read-header-timeout: must not be longer than read-timeout")
+	}
+	switch st.logFormat {
+	case "text", "json":
+	default:
+		add("log-format: %q must be text or json", st.logFormat)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(st.logLevel)); err != nil {
+		add("log-level: %q must be debug, info, warn or error", st.logLevel)
+	}
+	if st.retention < 0 {
+		add("retention: must not be negative")
+	}
+	switch st.idKind {
+	case "uuid", "ulid", "seq":
+	default:
+		add("ids: %q must be uuid, ulid or seq", st.idKind)
+	}
+
+	if st.auth.HS256KeyFile != "" && st.auth.HS256Secret != "" {
+		add("jwt-hs256-key and jwt-hs256-secret: set only one of them")
+	}
+	if st.auth.HS256Secret != "" && len(st.auth.HS256Secret) < minHMACKeyLength {
+		add("jwt-hs256-secret: must be at least %d bytes", minHMACKeyLength)
+	}
+	jwtKeys := st.auth.HS256KeyFile != "" || st.auth.HS256Secret != "" || st.auth.RS256KeyFile != ""
+	if (st.auth.Issuer != "" || st.auth.Audience != "") && !jwtKeys {
+		add("jwt-issuer and jwt-audience: need a JWT key")
+	}
+	if st.rolesFile != "" && st.auth.APIKeysFile == "" && !jwtKeys {
+		add("roles: needs authentication, configure API keys or JWT keys")
+	}
+	return errors.Join(errs...)
+}
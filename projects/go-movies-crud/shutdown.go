@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// serve runs server until ctx is done, then shuts it down gracefully. It
+// sets stopping, so /readyz fails, and keeps serving for delay, so load
+// balancers have time to notice. Then it stops accepting connections and
+// gives the requests in progress until drain to finish. Requests still
+// running after that are cut off.
+func serve(ctx context.Context, server *http.Server, delay, drain time.Duration, stopping *atomic.Bool) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return err // could not listen, the port is probably taken
+	case <-ctx.Done():
+	}
+
+	stopping.Store(true)
+	if delay > 0 {
+		slog.Info("shutting down, still serving while load balancers catch up", "delay", delay)
+		time.Sleep(delay)
+	}
+	slog.Info("shutting down, waiting for requests in progress", "drain", drain)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	err := server.Shutdown(shutdownCtx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		slog.Warn("requests still running after the drain deadline, closing them")
+		return server.Close()
+	}
+	return err
+}
+
+// inFlight counts the handlers that are running. Cutting off a connection
+// doesn't stop the handler serving it, so after a drain that timed out some
+// may still be using the store; main waits for them before closing it.
+type inFlight struct {
+	handlers sync.WaitGroup
+}
+
+func (f *inFlight) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.handlers.Add(1)
+		defer f.handlers.Done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// wait waits up to timeout for the running handlers to return, and reports
+// whether they did.
+func (f *inFlight) wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		f.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
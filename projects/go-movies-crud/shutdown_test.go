@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// wait holds on until the handlers that are running have returned.
+func TestInFlight(t *testing.T) {
+	var running inFlight
+	started, release := make(chan struct{}), make(chan struct{})
+	h := running.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/movies", nil))
+		close(done)
+	}()
+	<-started
+
+	if running.wait(10 * time.Millisecond) {
+		t.Fatal("wait returned while a handler was running")
+	}
+	close(release)
+	if !running.wait(time.Second) {
+		t.Fatal("wait timed out after the handler returned")
+	}
+	<-done
+}
@@ -0,0 +1,766 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite" // pure Go SQLite driver, no cgo needed
+)
+
+// migrations are applied in order, each one exactly once. The version of a
+// migration is its position in the slice plus one, so never edit or reorder
+// an entry that has shipped: add a new one at the end instead.
+var migrations = []string{
+	// 1: directors and movies
+	`CREATE TABLE directors (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		first_name TEXT NOT NULL,
+		last_name  TEXT NOT NULL,
+		UNIQUE (first_name, last_name)
+	);
+	CREATE TABLE movies (
+		id          TEXT PRIMARY KEY,
+		isbn        TEXT NOT NULL,
+		title       TEXT NOT NULL,
+		director_id INTEGER REFERENCES directors (id),
+		position    INTEGER NOT NULL
+	);
+	CREATE INDEX movies_position ON movies (position);`,
+
+	// 2: directors get text IDs like movies, so they can come from the same
+	// ID generators and be referenced from the API
+	`CREATE TABLE new_directors (
+		id         TEXT PRIMARY KEY,
+		first_name TEXT NOT NULL,
+		last_name  TEXT NOT NULL,
+		UNIQUE (first_name, last_name)
+	);
+	INSERT INTO new_directors (id, first_name, last_name)
+		SELECT CAST(id AS TEXT), first_name, last_name FROM directors;
+	CREATE TABLE new_movies (
+		id          TEXT PRIMARY KEY,
+		isbn        TEXT NOT NULL,
+		title       TEXT NOT NULL,
+		director_id TEXT REFERENCES new_directors (id),
+		position    INTEGER NOT NULL
+	);
+	INSERT INTO new_movies (id, isbn, title, director_id, position)
+		SELECT id, isbn, title, CAST(director_id AS TEXT), position FROM movies;
+	DROP TABLE movies;
+	DROP TABLE directors;
+	ALTER TABLE new_directors RENAME TO directors;
+	ALTER TABLE new_movies RENAME TO movies;
+	CREATE INDEX movies_position ON movies (position);
+	CREATE INDEX movies_director ON movies (director_id);`,
+
+	// 3: release date, runtime, genres, cast and ratings. The lists are
+	// stored as JSON arrays: they are always read and written together with
+	// their movie, so separate tables would only add joins.
+	`ALTER TABLE movies ADD COLUMN release_date TEXT NOT NULL DEFAULT '';
+	ALTER TABLE movies ADD COLUMN runtime INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE movies ADD COLUMN genres TEXT NOT NULL DEFAULT '[]';
+	ALTER TABLE movies ADD COLUMN cast_members TEXT NOT NULL DEFAULT '[]';
+	ALTER TABLE movies ADD COLUMN ratings TEXT NOT NULL DEFAULT '[]';`,
+
+	// 4: optimistic concurrency
+	`ALTER TABLE movies ADD COLUMN version INTEGER NOT NULL DEFAULT 1;`,
+
+	// 5: soft delete
+	`ALTER TABLE movies ADD COLUMN deleted_at TEXT;`,
+
+	// 6: change history. No foreign key to movies: the history is kept
+	// after a movie is purged.
+	`CREATE TABLE movie_events (
+		seq         INTEGER PRIMARY KEY AUTOINCREMENT,
+		movie_id    TEXT NOT NULL,
+		revision    INTEGER NOT NULL,
+		action      TEXT NOT NULL,
+		actor       TEXT NOT NULL,
+		at          TEXT NOT NULL,
+		movie       TEXT NOT NULL,
+		reverted_to INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX movie_events_movie ON movie_events (movie_id, seq);`,
+
+	// 7: no foreign key from movies to directors: deleting a director
+	// soft-deletes their movies, which keep its ID until they are purged.
+	// The store checks the director of every live movie itself.
+	`CREATE TABLE new_movies (
+		id           TEXT PRIMARY KEY,
+		isbn         TEXT NOT NULL,
+		title        TEXT NOT NULL,
+		director_id  TEXT,
+		position     INTEGER NOT NULL,
+		release_date TEXT NOT NULL DEFAULT '',
+		runtime      INTEGER NOT NULL DEFAULT 0,
+		genres       TEXT NOT NULL DEFAULT '[]',
+		cast_members TEXT NOT NULL DEFAULT '[]',
+		ratings      TEXT NOT NULL DEFAULT '[]',
+		version      INTEGER NOT NULL DEFAULT 1,
+		deleted_at   TEXT
+	);
+	INSERT INTO new_movies (id, isbn, title, director_id, position, release_date, runtime,
+			genres, cast_members, ratings, version, deleted_at)
+		SELECT id, isbn, title, director_id, position, release_date, runtime,
+			genres, cast_members, ratings, version, deleted_at FROM movies;
+	DROP TABLE movies;
+	ALTER TABLE new_movies RENAME TO movies;
+	CREATE INDEX movies_position ON movies (position);
+	CREATE INDEX movies_director ON movies (director_id);`,
+}
+
+// timeFormat is how timestamps are stored: always UTC and always the same
+// width, so comparing them as strings compares them as times.
+const timeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// sqliteStore keeps the movies in an SQLite database file.
+type sqliteStore struct {
+	db *sql.DB
+	tx *sql.Tx // set on the store Atomically hands to its fn
+}
+
+// newSQLiteStore opens (or creates) the database at path and applies any
+// pending migrations. A database created just now is seeded; one that was
+// already there is left as it is, even if it has been emptied since.
+func newSQLiteStore(path string, seed catalog) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer; one connection avoids "database is locked"
+	db.SetMaxOpenConns(1)
+
+	s := &sqliteStore{db: db}
+	created, err := s.migrate(len(migrations))
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if created {
+		if err := s.seed(seed); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// migrate brings the schema up to the given version, len(migrations) in
+// everything but tests, one transaction per migration. It reports whether
+// the database had no schema at all before.
+func (s *sqliteStore) migrate(to int) (created bool, err error) {
+	_, err = s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return false, err
+	}
+
+	var current int
+	if err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return false, err
+	}
+	if current > len(migrations) {
+		return false, fmt.Errorf("database schema version %d is newer than this program (%d)", current, len(migrations))
+	}
+
+	for i := current; i < to; i++ {
+		version := i + 1
+		tx, err := s.db.Begin()
+		if err != nil {
+			return false, err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return false, fmt.Errorf("migration %d: %w", version, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES (?)`, version); err != nil {
+			tx.Rollback()
+			return false, fmt.Errorf("migration %d: %w", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return false, fmt.Errorf("migration %d: %w", version, err)
+		}
+	}
+	return current == 0, nil
+}
+
+func (s *sqliteStore) seed(seed catalog) error {
+	for _, director := range seed.Directors {
+		if _, err := s.CreateDirector(director); err != nil {
+			return err
+		}
+	}
+	for _, movie := range seed.Movies {
+		if _, err := s.Create(movie); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ping starts a write that changes no rows and rolls it back, which fails
+// if the database is read-only or stays locked past the busy timeout.
+func (s *sqliteStore) Ping() error {
+	if s.tx != nil {
+		return errors.New("can't ping the store inside Atomically")
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`UPDATE schema_migrations SET version = version WHERE 0`)
+	return err
+}
+
+// Close releases the database once the queries in progress are done.
+func (s *sqliteStore) Close() error {
+	if s.tx != nil {
+		return errors.New("can't close the store inside Atomically")
+	}
+	return s.db.Close()
+}
+
+// handle is implemented by both *sql.DB and *sql.Tx.
+type handle interface {
+	querier
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// conn is what queries run on: the transaction of Atomically, if the store
+// is inside one, or else the database.
+func (s *sqliteStore) conn() handle {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.db
+}
+
+// txn is a transaction started by begin.
+type txn struct {
+	handle
+	commit, rollback func() error
+}
+
+func (t *txn) Commit() error   { return t.commit() }
+func (t *txn) Rollback() error { return t.rollback() }
+
+// begin starts a transaction for a single store method. Inside Atomically
+// it is a savepoint instead, so a method that fails half way undoes its own
+// changes and nothing else.
+func (s *sqliteStore) begin() (*txn, error) {
+	if s.tx == nil {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return nil, err
+		}
+		return &txn{handle: tx, commit: tx.Commit, rollback: tx.Rollback}, nil
+	}
+
+	if _, err := s.tx.Exec(`SAVEPOINT method`); err != nil {
+		return nil, err
+	}
+	done := false
+	return &txn{
+		handle: s.tx,
+		commit: func() error {
+			done = true
+			_, err := s.tx.Exec(`RELEASE method`)
+			return err
+		},
+		rollback: func() error {
+			if done {
+				return sql.ErrTxDone
+			}
+			done = true
+			_, err := s.tx.Exec(`ROLLBACK TO method; RELEASE method`)
+			return err
+		},
+	}, nil
+}
+
+// Atomically runs fn in a transaction, so everything it does through the
+// store it is given is committed together or not at all. The store has a
+// single connection, so other requests wait until it is done.
+func (s *sqliteStore) Atomically(fn func(Store) error) error {
+	if s.tx != nil {
+		return fn(s) // already inside a transaction
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(&sqliteStore{db: s.db, tx: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// The columns scanMovie reads, and the tables they come from.
+const (
+	movieColumns = `m.id, m.isbn, m.title, m.release_date, m.runtime,
+		m.genres, m.cast_members, m.ratings, m.version, m.deleted_at, m.director_id, d.first_name, d.last_name`
+	movieJoin   = `FROM movies m LEFT JOIN directors d ON d.id = m.director_id`
+	selectMovie = `SELECT ` + movieColumns + ` ` + movieJoin
+)
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanMovie reads a row of movieColumns, followed by any extra columns the
+// query selected into extra.
+func scanMovie(row rowScanner, extra ...any) (Movie, error) {
+	var movie Movie
+	var genres, cast, ratings string
+	var deletedAt, directorID, firstName, lastName sql.NullString
+	dest := []any{&movie.ID, &movie.ISBN, &movie.Title, &movie.ReleaseDate, &movie.Runtime,
+		&genres, &cast, &ratings, &movie.Version, &deletedAt, &directorID, &firstName, &lastName}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return Movie{}, err
+	}
+	if deletedAt.Valid {
+		t, err := time.Parse(timeFormat, deletedAt.String)
+		if err != nil {
+			return Movie{}, err
+		}
+		movie.DeletedAt = &t
+	}
+	for _, list := range []struct {
+		data string
+		dst  any
+	}{{genres, &movie.Genres}, {cast, &movie.Cast}, {ratings, &movie.Ratings}} {
+		if err := json.Unmarshal([]byte(list.data), list.dst); err != nil {
+			return Movie{}, err
+		}
+	}
+	movie.DirectorID = directorID.String
+	if firstName.Valid {
+		// the director exists: deleted movies may outlive theirs
+		movie.Director = &Director{ID: directorID.String, FirstName: firstName.String, LastName: lastName.String}
+	}
+	return movie, nil
+}
+
+func (s *sqliteStore) List() ([]Movie, error) {
+	rows, err := s.conn().Query(selectMovie + ` ORDER BY m.position`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []Movie{}
+	for rows.Next() {
+		movie, err := scanMovie(rows)
+		if err != nil {
+			return nil, err
+		}
+		movies = append(movies, movie)
+	}
+	return movies, rows.Err()
+}
+
+// walkPageSize is how many movies Walk reads per query. The store has a
+// single connection, so Walk must not keep a query open while fn runs.
+const walkPageSize = 500
+
+func (s *sqliteStore) Walk(fn func(Movie) error) error {
+	after := 0
+	for {
+		movies, positions, err := s.walkPage(after)
+		if err != nil {
+			return err
+		}
+		for _, movie := range movies {
+			if err := fn(movie); err != nil {
+				return err
+			}
+		}
+		if len(movies) < walkPageSize {
+			return nil
+		}
+		after = positions[len(positions)-1]
+	}
+}
+
+// walkPage reads the movies after the given position, and the position of
+// each.
+func (s *sqliteStore) walkPage(after int) ([]Movie, []int, error) {
+	rows, err := s.conn().Query(`SELECT `+movieColumns+`, m.position `+movieJoin+`
+		WHERE m.position > ? ORDER BY m.position LIMIT ?`, after, walkPageSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var movies []Movie
+	var positions []int
+	for rows.Next() {
+		var position int
+		movie, err := scanMovie(rows, &position)
+		if err != nil {
+			return nil, nil, err
+		}
+		movies = append(movies, movie)
+		positions = append(positions, position)
+	}
+	return movies, positions, rows.Err()
+}
+
+func (s *sqliteStore) Get(id string) (Movie, error) {
+	return getMovie(s.conn(), id)
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func getMovie(q querier, id string) (Movie, error) {
+	movie, err := scanMovie(q.QueryRow(selectMovie+` WHERE m.id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Movie{}, ErrMovieNotFound
+	}
+	return movie, err
+}
+
+// exists runs a SELECT EXISTS query.
+func exists(q querier, query string, args ...any) (bool, error) {
+	var found bool
+	err := q.QueryRow(`SELECT EXISTS (`+query+`)`, args...).Scan(&found)
+	return found, err
+}
+
+func (s *sqliteStore) Create(movie Movie) (Movie, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return Movie{}, err
+	}
+	defer tx.Rollback()
+
+	taken, err := exists(tx, `SELECT 1 FROM movies WHERE id = ?`, movie.ID)
+	if err != nil {
+		return Movie{}, err
+	}
+	if taken {
+		return Movie{}, ErrMovieExists
+	}
+	if err := checkDirector(tx, movie.DirectorID); err != nil {
+		return Movie{}, err
+	}
+
+	genres, cast, ratings, err := movieLists(movie)
+	if err != nil {
+		return Movie{}, err
+	}
+	_, err = tx.Exec(`INSERT INTO movies (id, isbn, title, director_id, release_date, runtime,
+			genres, cast_members, ratings, position)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, (SELECT COALESCE(MAX(position), 0) + 1 FROM movies))`,
+		movie.ID, movie.ISBN, movie.Title, movie.DirectorID, movie.ReleaseDate, movie.Runtime,
+		genres, cast, ratings)
+	if err != nil {
+		return Movie{}, err
+	}
+	created, err := getMovie(tx, movie.ID)
+	if err != nil {
+		return Movie{}, err
+	}
+	return created, tx.Commit()
+}
+
+func (s *sqliteStore) Update(id string, movie Movie) (Movie, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return Movie{}, err
+	}
+	defer tx.Rollback()
+
+	if err := checkDirector(tx, movie.DirectorID); err != nil {
+		return Movie{}, err
+	}
+	genres, cast, ratings, err := movieLists(movie)
+	if err != nil {
+		return Movie{}, err
+	}
+	res, err := tx.Exec(`UPDATE movies SET isbn = ?, title = ?, director_id = ?, release_date = ?,
+			runtime = ?, genres = ?, cast_members = ?, ratings = ?, version = version + 1
+		WHERE id = ? AND deleted_at IS NULL AND (? = 0 OR version = ?)`,
+		movie.ISBN, movie.Title, movie.DirectorID, movie.ReleaseDate,
+		movie.Runtime, genres, cast, ratings, id, movie.Version, movie.Version)
+	if err != nil {
+		return Movie{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Movie{}, missingOrModified(tx, id)
+	}
+	updated, err := getMovie(tx, id)
+	if err != nil {
+		return Movie{}, err
+	}
+	return updated, tx.Commit()
+}
+
+func (s *sqliteStore) Delete(id string, version int) error {
+	now := time.Now().UTC().Truncate(time.Second).Format(timeFormat)
+	res, err := s.conn().Exec(`UPDATE movies SET deleted_at = ?, version = version + 1
+		WHERE id = ? AND deleted_at IS NULL AND (? = 0 OR version = ?)`, now, id, version, version)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return missingOrModified(s.conn(), id)
+	}
+	return nil
+}
+
+func (s *sqliteStore) Restore(id string) (Movie, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return Movie{}, err
+	}
+	defer tx.Rollback()
+
+	movie, err := getMovie(tx, id)
+	if err != nil || movie.DeletedAt == nil {
+		return movie, err
+	}
+	if err := checkDirector(tx, movie.DirectorID); err != nil {
+		return Movie{}, err
+	}
+	_, err = tx.Exec(`UPDATE movies SET deleted_at = NULL, version = version + 1 WHERE id = ?`, id)
+	if err != nil {
+		return Movie{}, err
+	}
+	restored, err := getMovie(tx, id)
+	if err != nil {
+		return Movie{}, err
+	}
+	return restored, tx.Commit()
+}
+
+func (s *sqliteStore) Purge(deletedBefore time.Time) (int, error) {
+	res, err := s.conn().Exec(`DELETE FROM movies WHERE deleted_at < ?`,
+		deletedBefore.UTC().Format(timeFormat))
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// missingOrModified explains why a conditional UPDATE or DELETE touched no
+// rows: either the movie is gone (or deleted) or its version has changed.
+func missingOrModified(q querier, id string) error {
+	found, err := exists(q, `SELECT 1 FROM movies WHERE id = ? AND deleted_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrMovieNotFound
+	}
+	return ErrVersionMismatch
+}
+
+// movieLists encodes the movie's genres, cast and ratings as JSON arrays
+// for their columns.
+func movieLists(movie Movie) (genres, cast, ratings string, err error) {
+	encode := func(v any) string {
+		if err != nil {
+			return ""
+		}
+		var data []byte
+		data, err = json.Marshal(v)
+		return string(data)
+	}
+	genres = encode(nonNil(movie.Genres))
+	cast = encode(nonNil(movie.Cast))
+	ratings = encode(nonNil(movie.Ratings))
+	return genres, cast, ratings, err
+}
+
+// nonNil returns an empty slice for nil, so it is stored as [] not null.
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
+
+// checkDirector returns ErrDirectorNotFound unless the director exists.
+func checkDirector(q querier, id string) error {
+	found, err := exists(q, `SELECT 1 FROM directors WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrDirectorNotFound
+	}
+	return nil
+}
+
+func (s *sqliteStore) ListDirectors() ([]Director, error) {
+	rows, err := s.conn().Query(`SELECT id, first_name, last_name FROM directors ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	directors := []Director{}
+	for rows.Next() {
+		var d Director
+		if err := rows.Scan(&d.ID, &d.FirstName, &d.LastName); err != nil {
+			return nil, err
+		}
+		directors = append(directors, d)
+	}
+	return directors, rows.Err()
+}
+
+func (s *sqliteStore) GetDirector(id string) (Director, error) {
+	d := Director{ID: id}
+	err := s.conn().QueryRow(`SELECT first_name, last_name FROM directors WHERE id = ?`, id).
+		Scan(&d.FirstName, &d.LastName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Director{}, ErrDirectorNotFound
+	}
+	return d, err
+}
+
+func (s *sqliteStore) CreateDirector(director Director) (Director, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return Director{}, err
+	}
+	defer tx.Rollback()
+
+	taken, err := exists(tx, `SELECT 1 FROM directors WHERE id = ?`, director.ID)
+	if err != nil {
+		return Director{}, err
+	}
+	if taken {
+		return Director{}, ErrDirectorExists
+	}
+	if err := checkDirectorName(tx, director); err != nil {
+		return Director{}, err
+	}
+	_, err = tx.Exec(`INSERT INTO directors (id, first_name, last_name) VALUES (?, ?, ?)`,
+		director.ID, director.FirstName, director.LastName)
+	if err != nil {
+		return Director{}, err
+	}
+	return director, tx.Commit()
+}
+
+func (s *sqliteStore) UpdateDirector(id string, director Director) (Director, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return Director{}, err
+	}
+	defer tx.Rollback()
+
+	director.ID = id
+	if err := checkDirectorName(tx, director); err != nil {
+		return Director{}, err
+	}
+	res, err := tx.Exec(`UPDATE directors SET first_name = ?, last_name = ? WHERE id = ?`,
+		director.FirstName, director.LastName, id)
+	if err != nil {
+		return Director{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Director{}, ErrDirectorNotFound
+	}
+	return director, tx.Commit()
+}
+
+func (s *sqliteStore) DeleteDirector(id string, cascade bool) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkDirector(tx, id); err != nil {
+		return err
+	}
+	inUse, err := exists(tx, `SELECT 1 FROM movies WHERE director_id = ? AND deleted_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if inUse && !cascade {
+		return ErrDirectorInUse
+	}
+	now := time.Now().UTC().Truncate(time.Second).Format(timeFormat)
+	_, err = tx.Exec(`UPDATE movies SET deleted_at = ?, version = version + 1
+		WHERE director_id = ? AND deleted_at IS NULL`, now, id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM directors WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkDirectorName returns ErrDirectorNameTaken if a director other than
+// director.ID already has the same name.
+func checkDirectorName(q querier, director Director) error {
+	taken, err := exists(q, `SELECT 1 FROM directors WHERE first_name = ? AND last_name = ? AND id <> ?`,
+		director.FirstName, director.LastName, director.ID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrDirectorNameTaken
+	}
+	return nil
+}
+
+func (s *sqliteStore) AddEvent(event MovieEvent) error {
+	movie, err := json.Marshal(event.Movie)
+	if err != nil {
+		return err
+	}
+	_, err = s.conn().Exec(`INSERT INTO movie_events (movie_id, revision, action, actor, at, movie, reverted_to)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		event.MovieID, event.Revision, event.Action, event.Actor,
+		event.At.UTC().Format(timeFormat), movie, event.RevertedTo)
+	return err
+}
+
+func (s *sqliteStore) History(movieID string) ([]MovieEvent, error) {
+	rows, err := s.conn().Query(`SELECT movie_id, revision, action, actor, at, movie, reverted_to
+		FROM movie_events WHERE movie_id = ? ORDER BY seq`, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []MovieEvent{}
+	for rows.Next() {
+		var event MovieEvent
+		var at, movie string
+		err := rows.Scan(&event.MovieID, &event.Revision, &event.Action, &event.Actor,
+			&at, &movie, &event.RevertedTo)
+		if err != nil {
+			return nil, err
+		}
+		if event.At, err = time.Parse(timeFormat, at); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(movie), &event.Movie); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
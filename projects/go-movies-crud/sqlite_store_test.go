@@ -0,0 +1,67 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A database made by an older version is upgraded in place: its data is
+// kept and it isn't seeded.
+func TestSQLiteMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "movies.db")
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := &sqliteStore{db: db}
+	// version 2 has text IDs but no release dates, versions or history
+	if _, err := old.migrate(2); err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO directors (id, first_name, last_name) VALUES ('7', 'Sofia', 'Coppola');
+		INSERT INTO movies (id, isbn, title, director_id, position) VALUES ('m1', '9780000000001', 'Lost in Translation', '7', 1)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	s, err := newSQLiteStore(path, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var version int
+	if err := s.db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version); err != nil || version != len(migrations) {
+		t.Errorf("schema version %d, %v; want %d", version, err, len(migrations))
+	}
+	movies, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(movies) != 1 {
+		t.Fatalf("%d movies after the upgrade, want the 1 that was there", len(movies))
+	}
+	movie := movies[0]
+	if movie.ID != "m1" || movie.Version != 1 || movie.DeletedAt != nil || movie.Genres == nil || movie.Director == nil || movie.Director.LastName != "Coppola" {
+		t.Errorf("upgraded movie = %+v", movie)
+	}
+	if err := s.AddEvent(MovieEvent{MovieID: "m1", Revision: 1, Action: actionCreate}); err != nil {
+		t.Errorf("AddEvent after the upgrade: %v", err)
+	}
+	s.Close()
+
+	// a database from a newer version is left alone
+	db, err = sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO schema_migrations (version) VALUES (?)`, len(migrations)+1); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if _, err := newSQLiteStore(path, seed); err == nil || !strings.Contains(err.Error(), "newer than this program") {
+		t.Errorf("opening a newer database: error %v", err)
+	}
+}
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrMovieNotFound is returned by a MovieStore when no movie has the given ID.
+var ErrMovieNotFound = errors.New("movie not found")
+
+// ErrMovieExists is returned by Create when the ID is already taken.
+var ErrMovieExists = errors.New("movie already exists")
+
+// ErrVersionMismatch is returned by Update and Delete when the movie has
+// been changed since the version the caller expected.
+var ErrVersionMismatch = errors.New("movie has been modified")
+
+var (
+	// ErrDirectorNotFound is returned when no director has the given ID,
+	// including when a movie points to a director that doesn't exist.
+	ErrDirectorNotFound = errors.New("director not found")
+	// ErrDirectorExists is returned by CreateDirector when the ID is taken.
+	ErrDirectorExists = errors.New("director already exists")
+	// ErrDirectorNameTaken is returned when another director already has
+	// the same first and last name.
+	ErrDirectorNameTaken = errors.New("a director with that name already exists")
+	// ErrDirectorInUse is returned when deleting a director that still has
+	// movies, unless the delete cascades.
+	ErrDirectorInUse = errors.New("director still has movies")
+)
+
+// Store is everything the handlers need from a storage backend.
+//
+// Atomically runs fn with a Store whose changes are kept only if fn returns
+// nil: either all of them happen or none do. Other writers never see part of
+// them. The Store given to fn must not be used after fn returns.
+//
+// Ping checks that the backend can be read and written right now, without
+// changing anything. Readiness probes use it.
+//
+// Close makes sure every change has reached the backend and releases it.
+// The store must not be used afterwards.
+type Store interface {
+	MovieStore
+	DirectorStore
+	HistoryStore
+	Atomically(fn func(Store) error) error
+	Ping() error
+	Close() error
+}
+
+// MovieStore is the storage backend used by the movie handlers.
+// Swapping the implementation lets us change where movies live
+// (memory, file, database...) without touching the HTTP code.
+//
+// IDs are unique: Create must fail with ErrMovieExists rather than store a
+// second movie with the same ID. Update replaces a movie in place, keeping
+// its position in List.
+//
+// Every movie has a Version, 1 when created and incremented by every
+// Update. Update (with movie.Version) and Delete take the version the
+// caller last saw and fail with ErrVersionMismatch if the stored movie has
+// moved on since; version 0 skips the check. The Version field is otherwise
+// ignored on the way in.
+//
+// Movies point to their director through DirectorID, which must exist
+// (ErrDirectorNotFound otherwise). The Director field is ignored on the way
+// in and filled in from the directors on the way out.
+//
+// Delete is a soft delete: it sets DeletedAt and bumps the version, and the
+// movie stays until Restore brings it back or Purge removes it for good.
+// List and Get still return deleted movies, so callers must check DeletedAt;
+// Update and Delete treat them as missing. Their IDs stay taken until purged.
+// A deleted movie whose director has been deleted too can't be restored
+// (ErrDirectorNotFound).
+//
+// Walk calls fn for every movie in List order, stopping at the first error,
+// without loading the whole catalog at once where the backend allows it.
+// Changes made during a walk may or may not be seen by it.
+type MovieStore interface {
+	List() ([]Movie, error)
+	Walk(fn func(Movie) error) error
+	Get(id string) (Movie, error)
+	Create(movie Movie) (Movie, error)
+	Update(id string, movie Movie) (Movie, error)
+	Delete(id string, version int) error
+	Restore(id string) (Movie, error)
+	Purge(deletedBefore time.Time) (int, error)
+}
+
+// DirectorStore holds the directors movies point to. Director IDs and full
+// names are unique. DeleteDirector fails with ErrDirectorInUse while movies
+// that aren't deleted still point to the director, unless cascade is set,
+// in which case it deletes them as Delete does. Deleted movies keep pointing
+// to the director they had until they are purged.
+type DirectorStore interface {
+	ListDirectors() ([]Director, error)
+	GetDirector(id string) (Director, error)
+	CreateDirector(director Director) (Director, error)
+	UpdateDirector(id string, director Director) (Director, error)
+	DeleteDirector(id string, cascade bool) error
+}
+
+// HistoryStore keeps the change history of every movie. Events can only be
+// added, never changed or removed, and History returns a movie's events in
+// the order they were added. The history outlives the movie itself.
+type HistoryStore interface {
+	AddEvent(event MovieEvent) error
+	History(movieID string) ([]MovieEvent, error)
+}
+
+// catalog is a full copy of a store's data. It is what a new store is
+// seeded with, and the format of the JSON file.
+type catalog struct {
+	Directors []Director   `json:"directors"`
+	Movies    []Movie      `json:"movies"`
+	History   []MovieEvent `json:"history,omitempty"`
+}
+
+// memoryStore keeps the movies and directors in slices. Everything is lost
+// on restart.
+//
+// net/http runs every request on its own goroutine, so all access to the
+// slices goes through mu. Movies are stored without their Director and
+// cloned on the way in and out, so callers never share a slice or a
+// *Director with the store.
+type memoryStore struct {
+	mu        sync.RWMutex
+	directors []Director
+	movies    []Movie
+	events    []MovieEvent
+}
+
+// newMemoryStore returns a store holding the seed catalog. Events are never
+// changed once added, so the store shares seed.History rather than copying
+// it: the caller must not change it either.
+func newMemoryStore(seed catalog) *memoryStore {
+	s := &memoryStore{directors: append([]Director(nil), seed.Directors...)}
+	s.events = seed.History[:len(seed.History):len(seed.History)]
+	for _, movie := range seed.Movies {
+		movie = movie.clone()
+		movie.Director = nil
+		if movie.Version == 0 {
+			movie.Version = 1 // saved before movies had versions
+		}
+		s.movies = append(s.movies, movie)
+	}
+	return s
+}
+
+// snapshot returns a copy of everything in the store.
+func (s *memoryStore) snapshot() catalog {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.snapshotLocked()
+}
+
+// snapshotLocked is snapshot for callers that hold s.mu.
+func (s *memoryStore) snapshotLocked() catalog {
+	c := catalog{Directors: append([]Director{}, s.directors...), Movies: []Movie{}}
+	for _, movie := range s.movies {
+		c.Movies = append(c.Movies, movie.clone())
+	}
+	// events are never changed, so the copy can share them; capping the
+	// slice makes an append to either one copy it
+	c.History = s.events[:len(s.events):len(s.events)]
+	return c
+}
+
+// Atomically runs fn against a copy of the store and keeps the copy if fn
+// succeeds. The store stays locked until then.
+func (s *memoryStore) Atomically(fn func(Store) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx := newMemoryStore(s.snapshotLocked())
+	if err := fn(tx); err != nil {
+		return err
+	}
+	s.directors, s.movies, s.events = tx.directors, tx.movies, tx.events
+	return nil
+}
+
+// Ping always succeeds: memory is always there.
+func (s *memoryStore) Ping() error {
+	return nil
+}
+
+// Close does nothing: there is nothing to flush or release.
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+// expand returns a copy of the movie with its Director filled in.
+// Callers must hold s.mu.
+func (s *memoryStore) expand(movie Movie) Movie {
+	movie = movie.clone()
+	movie.Director = nil
+	if i := s.directorIndex(movie.DirectorID); i >= 0 {
+		director := s.directors[i]
+		movie.Director = &director
+	}
+	return movie
+}
+
+func (s *memoryStore) movieIndex(id string) int {
+	for index, item := range s.movies {
+		if item.ID == id {
+			return index
+		}
+	}
+	return -1
+}
+
+func (s *memoryStore) directorIndex(id string) int {
+	for index, item := range s.directors {
+		if item.ID == id {
+			return index
+		}
+	}
+	return -1
+}
+
+func (s *memoryStore) List() ([]Movie, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	// return a copy so callers can't change our slice
+	movies := make([]Movie, 0, len(s.movies))
+	for _, item := range s.movies {
+		movies = append(movies, s.expand(item))
+	}
+	return movies, nil
+}
+
+// walkChunk is how many movies memoryStore.Walk copies at a time.
+const walkChunk = 100
+
+// Walk copies walkChunk movies at a time under the lock and calls fn for
+// them after releasing it, so neither a slow fn nor a big catalog holds up
+// writers or fills memory. Movies may be removed between two chunks, which
+// moves the others up, so Walk finds its place again by the last movie of
+// the previous chunk that is still there.
+func (s *memoryStore) Walk(fn func(Movie) error) error {
+	next := 0
+	var previous []string // IDs of the previous chunk
+	for {
+		s.mu.RLock()
+		if n := len(previous); n > 0 && (next > len(s.movies) || s.movies[next-1].ID != previous[n-1]) {
+			next = min(next-n, len(s.movies))
+			for i := n - 1; i >= 0; i-- {
+				if at := s.movieIndex(previous[i]); at >= 0 {
+					next = at + 1
+					break
+				}
+			}
+		}
+		end := min(next+walkChunk, len(s.movies))
+		chunk := make([]Movie, 0, end-next)
+		for _, movie := range s.movies[next:end] {
+			chunk = append(chunk, s.expand(movie))
+		}
+		s.mu.RUnlock()
+
+		if len(chunk) == 0 {
+			return nil
+		}
+		previous = previous[:0]
+		for _, movie := range chunk {
+			if err := fn(movie); err != nil {
+				return err
+			}
+			previous = append(previous, movie.ID)
+		}
+		next = end
+	}
+}
+
+func (s *memoryStore) Get(id string) (Movie, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if i := s.movieIndex(id); i >= 0 {
+		return s.expand(s.movies[i]), nil
+	}
+	return Movie{}, ErrMovieNotFound
+}
+
+func (s *memoryStore) Create(movie Movie) (Movie, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.movieIndex(movie.ID) >= 0 {
+		return Movie{}, ErrMovieExists
+	}
+	if s.directorIndex(movie.DirectorID) < 0 {
+		return Movie{}, ErrDirectorNotFound
+	}
+	movie = movie.clone()
+	movie.Director = nil
+	movie.Version = 1
+	movie.DeletedAt = nil
+	s.movies = append(s.movies, movie)
+	return s.expand(movie), nil
+}
+
+func (s *memoryStore) Update(id string, movie Movie) (Movie, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index := s.movieIndex(id)
+	if index < 0 || s.movies[index].DeletedAt != nil {
+		return Movie{}, ErrMovieNotFound
+	}
+	current := s.movies[index].Version
+	if movie.Version != 0 && movie.Version != current {
+		return Movie{}, ErrVersionMismatch
+	}
+	if s.directorIndex(movie.DirectorID) < 0 {
+		return Movie{}, ErrDirectorNotFound
+	}
+	// replace in place so the movie keeps its position
+	movie = movie.clone()
+	movie.ID = id
+	movie.Director = nil
+	movie.Version = current + 1
+	movie.DeletedAt = nil
+	s.movies[index] = movie
+	return s.expand(movie), nil
+}
+
+func (s *memoryStore) Delete(id string, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index := s.movieIndex(id)
+	if index < 0 || s.movies[index].DeletedAt != nil {
+		return ErrMovieNotFound
+	}
+	movie := &s.movies[index]
+	if version != 0 && version != movie.Version {
+		return ErrVersionMismatch
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	movie.DeletedAt = &now
+	movie.Version++
+	return nil
+}
+
+// Restore undeletes a movie. Restoring a movie that isn't deleted does
+// nothing.
+func (s *memoryStore) Restore(id string) (Movie, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index := s.movieIndex(id)
+	if index < 0 {
+		return Movie{}, ErrMovieNotFound
+	}
+	movie := &s.movies[index]
+	if movie.DeletedAt != nil {
+		if s.directorIndex(movie.DirectorID) < 0 {
+			return Movie{}, ErrDirectorNotFound
+		}
+		movie.DeletedAt = nil
+		movie.Version++
+	}
+	return s.expand(*movie), nil
+}
+
+// Purge removes the movies deleted before the given time and returns how
+// many there were.
+func (s *memoryStore) Purge(deletedBefore time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.movies[:0]
+	for _, movie := range s.movies {
+		if movie.DeletedAt == nil || !movie.DeletedAt.Before(deletedBefore) {
+			kept = append(kept, movie)
+		}
+	}
+	n := len(s.movies) - len(kept)
+	clear(s.movies[len(kept):])
+	s.movies = kept
+	return n, nil
+}
+
+func (s *memoryStore) ListDirectors() ([]Director, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Director{}, s.directors...), nil
+}
+
+func (s *memoryStore) GetDirector(id string) (Director, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if i := s.directorIndex(id); i >= 0 {
+		return s.directors[i], nil
+	}
+	return Director{}, ErrDirectorNotFound
+}
+
+// nameTaken reports whether a director other than id has the same name.
+// Callers must hold s.mu.
+func (s *memoryStore) nameTaken(id string, director Director) bool {
+	for _, item := range s.directors {
+		if item.ID != id && item.FirstName == director.FirstName && item.LastName == director.LastName {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memoryStore) CreateDirector(director Director) (Director, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.directorIndex(director.ID) >= 0 {
+		return Director{}, ErrDirectorExists
+	}
+	if s.nameTaken(director.ID, director) {
+		return Director{}, ErrDirectorNameTaken
+	}
+	s.directors = append(s.directors, director)
+	return director, nil
+}
+
+func (s *memoryStore) UpdateDirector(id string, director Director) (Director, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index := s.directorIndex(id)
+	if index < 0 {
+		return Director{}, ErrDirectorNotFound
+	}
+	if s.nameTaken(id, director) {
+		return Director{}, ErrDirectorNameTaken
+	}
+	director.ID = id
+	s.directors[index] = director
+	return director, nil
+}
+
+func (s *memoryStore) DeleteDirector(id string, cascade bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index := s.directorIndex(id)
+	if index < 0 {
+		return ErrDirectorNotFound
+	}
+
+	var movies []int
+	for i, movie := range s.movies {
+		if movie.DirectorID == id && movie.DeletedAt == nil {
+			movies = append(movies, i)
+		}
+	}
+	if len(movies) > 0 && !cascade {
+		return ErrDirectorInUse
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, i := range movies {
+		s.movies[i].DeletedAt = &now
+		s.movies[i].Version++
+	}
+	s.directors = append(s.directors[:index], s.directors[index+1:]...)
+	return nil
+}
+
+func (s *memoryStore) AddEvent(event MovieEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event.clone())
+	return nil
+}
+
+// eventsSince returns the events added after the first n.
+func (s *memoryStore) eventsSince(n int) []MovieEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.events[n:len(s.events):len(s.events)]
+}
+
+func (s *memoryStore) History(movieID string) ([]MovieEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	events := []MovieEvent{}
+	for _, event := range s.events {
+		if event.MovieID == movieID {
+			events = append(events, event.clone())
+		}
+	}
+	return events, nil
+}
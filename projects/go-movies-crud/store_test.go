@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// backends opens each kind of store on the sample catalog. open is called
+// again with the same directory to reopen a store that keeps its data.
+var backends = []struct {
+	name       string
+	persistent bool
+	open       func(t *testing.T, dir string) Store
+}{
+	{"memory", false, func(t *testing.T, dir string) Store {
+		return newMemoryStore(seed)
+	}},
+	{"file", true, func(t *testing.T, dir string) Store {
+		return openFileStore(t, filepath.Join(dir, "movies.json"))
+	}},
+	{"sqlite", true, func(t *testing.T, dir string) Store {
+		t.Helper()
+		s, err := newSQLiteStore(filepath.Join(dir, "movies.db"), seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	}},
+}
+
+// TestStoreContract checks that every backend behaves as the Store
+// interface says.
+func TestStoreContract(t *testing.T) {
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := backend.open(t, dir)
+			check := func(what string, err, want error) {
+				t.Helper()
+				if !errors.Is(err, want) {
+					t.Errorf("%s: error %v, want %v", what, err, want)
+				}
+			}
+
+			if err := s.Ping(); err != nil {
+				t.Errorf("Ping: %v", err)
+			}
+			movies, err := s.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(movies) != len(seed.Movies) {
+				t.Fatalf("%d movies, want the %d seeded ones", len(movies), len(seed.Movies))
+			}
+			for i, movie := range movies {
+				if movie.ID != seed.Movies[i].ID || movie.Version != 1 || movie.Director == nil || movie.Director.ID != movie.DirectorID {
+					t.Errorf("movie %d = %+v, want seeded movie %s at version 1 with its director", i, movie, seed.Movies[i].ID)
+				}
+			}
+
+			// create
+			created, err := s.Create(Movie{ID: "new", ISBN: "9780000000001", Title: "New", DirectorID: "1",
+				Genres: []string{"Drama"}, Version: 7})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if created.Version != 1 || created.Director == nil || created.Director.LastName != "Lucas" || len(created.Genres) != 1 {
+				t.Errorf("created %+v", created)
+			}
+			_, err = s.Create(Movie{ID: "new", DirectorID: "1"})
+			check("Create with a taken ID", err, ErrMovieExists)
+			_, err = s.Create(Movie{ID: "other", DirectorID: "99"})
+			check("Create with a missing director", err, ErrDirectorNotFound)
+
+			// update keeps the position and checks the version
+			updated, err := s.Update("1", Movie{ISBN: "9780345341464", Title: "Star Wars: A New Hope", DirectorID: "1", Version: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if updated.ID != "1" || updated.Version != 2 {
+				t.Errorf("updated %+v, want movie 1 at version 2", updated)
+			}
+			_, err = s.Update("1", Movie{DirectorID: "1", Version: 1})
+			check("Update with a stale version", err, ErrVersionMismatch)
+			_, err = s.Update("99", Movie{DirectorID: "1"})
+			check("Update of a missing movie", err, ErrMovieNotFound)
+			_, err = s.Update("1", Movie{DirectorID: "99"})
+			check("Update with a missing director", err, ErrDirectorNotFound)
+			if movies, _ := s.List(); movies[0].Title != "Star Wars: A New Hope" || movies[len(movies)-1].ID != "new" {
+				t.Errorf("List order changed by Update: %s first, %s last", movies[0].ID, movies[len(movies)-1].ID)
+			}
+
+			// delete is soft, until purged
+			check("Delete with a stale version", s.Delete("1", 1), ErrVersionMismatch)
+			if err := s.Delete("1", 2); err != nil {
+				t.Fatal(err)
+			}
+			deleted, err := s.Get("1")
+			if err != nil || deleted.DeletedAt == nil || deleted.Version != 3 {
+				t.Errorf("deleted movie = %+v, %v; want it kept with DeletedAt at version 3", deleted, err)
+			}
+			check("Delete twice", s.Delete("1", 0), ErrMovieNotFound)
+			_, err = s.Update("1", Movie{DirectorID: "1"})
+			check("Update of a deleted movie", err, ErrMovieNotFound)
+			_, err = s.Create(Movie{ID: "1", DirectorID: "1"})
+			check("Create with a deleted movie's ID", err, ErrMovieExists)
+
+			restored, err := s.Restore("1")
+			if err != nil || restored.DeletedAt != nil || restored.Version != 4 {
+				t.Errorf("restored movie = %+v, %v; want it live at version 4", restored, err)
+			}
+			if again, err := s.Restore("1"); err != nil || again.Version != 4 {
+				t.Errorf("restoring a live movie gave version %d, %v; want 4", again.Version, err)
+			}
+			_, err = s.Restore("99")
+			check("Restore of a missing movie", err, ErrMovieNotFound)
+
+			if err := s.Delete("new", 0); err != nil {
+				t.Fatal(err)
+			}
+			if n, err := s.Purge(time.Now().Add(-time.Hour)); err != nil || n != 0 {
+				t.Errorf("Purge of older deletions removed %d, %v; want 0", n, err)
+			}
+			if n, err := s.Purge(time.Now().Add(time.Second)); err != nil || n != 1 {
+				t.Errorf("Purge removed %d, %v; want 1", n, err)
+			}
+			_, err = s.Get("new")
+			check("Get after Purge", err, ErrMovieNotFound)
+
+			var walked []string
+			err = s.Walk(func(movie Movie) error {
+				walked = append(walked, movie.ID)
+				return nil
+			})
+			if err != nil || len(walked) != len(seed.Movies) || walked[0] != "1" {
+				t.Errorf("Walk visited %v, %v", walked, err)
+			}
+
+			// directors
+			_, err = s.CreateDirector(Director{ID: "1", FirstName: "Someone", LastName: "Else"})
+			check("CreateDirector with a taken ID", err, ErrDirectorExists)
+			_, err = s.CreateDirector(Director{ID: "5", FirstName: "George", LastName: "Lucas"})
+			check("CreateDirector with a taken name", err, ErrDirectorNameTaken)
+			if _, err := s.CreateDirector(Director{ID: "5", FirstName: "Denis", LastName: "Villeneuve"}); err != nil {
+				t.Fatal(err)
+			}
+			_, err = s.UpdateDirector("5", Director{FirstName: "Peter", LastName: "Jackson"})
+			check("UpdateDirector to a taken name", err, ErrDirectorNameTaken)
+			_, err = s.UpdateDirector("99", Director{FirstName: "No", LastName: "One"})
+			check("UpdateDirector of a missing director", err, ErrDirectorNotFound)
+			if d, err := s.UpdateDirector("5", Director{FirstName: "Denis", LastName: "Villeneuve Jr."}); err != nil || d.ID != "5" {
+				t.Errorf("UpdateDirector = %+v, %v", d, err)
+			}
+			if directors, _ := s.ListDirectors(); len(directors) != len(seed.Directors)+1 || directors[len(directors)-1].ID != "5" {
+				t.Errorf("ListDirectors = %+v", directors)
+			}
+			if err := s.DeleteDirector("5", false); err != nil {
+				t.Errorf("DeleteDirector of an unused director: %v", err)
+			}
+			check("DeleteDirector of a missing director", s.DeleteDirector("5", false), ErrDirectorNotFound)
+			check("DeleteDirector of a director in use", s.DeleteDirector("2", false), ErrDirectorInUse)
+			if _, err := s.Create(Movie{ID: "old", DirectorID: "2"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Delete("old", 0); err != nil {
+				t.Fatal(err)
+			}
+			// a cascade deletes the live movies and leaves the deleted ones be
+			if err := s.DeleteDirector("2", true); err != nil {
+				t.Fatal(err)
+			}
+			_, err = s.GetDirector("2")
+			check("GetDirector after DeleteDirector", err, ErrDirectorNotFound)
+			cascaded, err := s.Get("2")
+			if err != nil || cascaded.DeletedAt == nil || cascaded.Version != 2 || cascaded.DirectorID != "2" || cascaded.Director != nil {
+				t.Errorf("movie of a deleted director = %+v, %v; want it deleted at version 2, still with its director's ID", cascaded, err)
+			}
+			if old, err := s.Get("old"); err != nil || old.Version != 2 {
+				t.Errorf("deleted movie of a deleted director = %+v, %v; want it untouched at version 2", old, err)
+			}
+			_, err = s.Restore("2")
+			check("Restore of a movie whose director is gone", err, ErrDirectorNotFound)
+
+			// history
+			for revision := 1; revision <= 2; revision++ {
+				if err := s.AddEvent(MovieEvent{MovieID: "3", Revision: revision, Action: actionUpdate, Actor: "alice", At: time.Now()}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			events, err := s.History("3")
+			if err != nil || len(events) != 2 || events[0].Revision != 1 || events[1].Actor != "alice" {
+				t.Errorf("History = %+v, %v", events, err)
+			}
+			if events, err := s.History("2"); err != nil || events == nil || len(events) != 0 {
+				t.Errorf("History of a movie without events = %#v, %v; want an empty list", events, err)
+			}
+
+			// Atomically keeps everything or nothing
+			failed := errors.New("failed")
+			err = s.Atomically(func(tx Store) error {
+				if _, err := tx.Create(Movie{ID: "tx", DirectorID: "1"}); err != nil {
+					return err
+				}
+				if err := tx.AddEvent(MovieEvent{MovieID: "tx", Revision: 1, Action: actionCreate}); err != nil {
+					return err
+				}
+				return failed
+			})
+			check("Atomically", err, failed)
+			_, err = s.Get("tx")
+			check("Get of a movie created in a failed Atomically", err, ErrMovieNotFound)
+			if n := historyLen(t, s, "tx"); n != 0 {
+				t.Errorf("failed Atomically left %d events", n)
+			}
+			err = s.Atomically(func(tx Store) error {
+				_, err := tx.Create(Movie{ID: "tx", DirectorID: "1"})
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Get("tx"); err != nil {
+				t.Errorf("Get of a movie created in Atomically: %v", err)
+			}
+
+			if !backend.persistent {
+				return
+			}
+			// everything survives a restart, and an emptied store isn't
+			// seeded again
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			s = backend.open(t, dir)
+			if movie, err := s.Get("1"); err != nil || movie.Version != 4 || movie.Title != "Star Wars: A New Hope" {
+				t.Errorf("movie 1 after a restart = %+v, %v", movie, err)
+			}
+			if n := historyLen(t, s, "3"); n != 2 {
+				t.Errorf("movie 3 has %d events after a restart, want 2", n)
+			}
+			for _, d := range seed.Directors {
+				if d.ID != "2" {
+					if err := s.DeleteDirector(d.ID, true); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if _, err := s.Purge(time.Now().Add(time.Second)); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			s = backend.open(t, dir)
+			if movies, _ := s.List(); len(movies) != 0 {
+				t.Errorf("emptied store has %d movies after a restart, want 0", len(movies))
+			}
+			if directors, _ := s.ListDirectors(); len(directors) != 0 {
+				t.Errorf("emptied store has %d directors after a restart, want 0", len(directors))
+			}
+		})
+	}
+}
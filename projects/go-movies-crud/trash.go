@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// getLive returns a movie, or ErrMovieNotFound if it has been deleted.
+func (s *server) getLive(id string) (Movie, error) {
+	movie, err := s.store.Get(id)
+	if err == nil && movie.DeletedAt != nil {
+		return Movie{}, ErrMovieNotFound
+	}
+	return movie, err
+}
+
+// restoreMovie handles POST /movies/{id}:restore, which brings back a
+// deleted movie that hasn't been purged yet. Restoring a movie that isn't
+// deleted just returns it, and adds nothing to its history.
+func (s *server) restoreMovie(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	var movie Movie
+	err := s.store.Atomically(func(store Store) error {
+		var err error
+		if movie, err = store.Get(params["id"]); err != nil || movie.DeletedAt == nil {
+			return err
+		}
+		if movie, err = store.Restore(params["id"]); err != nil {
+			return err
+		}
+		return s.in(store).record(r, MovieEvent{Action: actionRestore, Movie: movie})
+	})
+	if errors.Is(err, ErrMovieNotFound) {
+		writeError(w, http.StatusNotFound, codeNotFound, "movie not found")
+		return
+	}
+	if errors.Is(err, ErrDirectorNotFound) {
+		writeError(w, http.StatusConflict, codeConflict, "the movie's director has been deleted")
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	writeMovie(w, http.StatusOK, movie)
+}
+
+// purgeDeleted removes movies that have been deleted for longer than
+// retention, checking every interval until ctx is done.
+func purgeDeleted(ctx context.Context, store MovieStore, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := store.Purge(time.Now().Add(-retention))
+		if err != nil {
+			slog.Error("purging deleted movies", "err", err)
+		} else if n > 0 {
+			slog.Info("purged deleted movies", "count", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeInterval is how often purgeDeleted runs: hourly, or more often when
+// the retention is short, so movies don't outlive it by much.
+func purgeInterval(retention time.Duration) time.Duration {
+	return max(min(retention/10, time.Hour), time.Second)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRestoreMovie(t *testing.T) {
+	h := newTestServer(t).routes()
+	if resp := do(t, h, "DELETE", "/movies/1", ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE: status %d, want 204", resp.StatusCode)
+	}
+
+	// deleted movies are only seen when asked for
+	if resp := do(t, h, "GET", "/movies/1", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET a deleted movie: status %d, want 404", resp.StatusCode)
+	}
+	var movie Movie
+	decodeBody(t, do(t, h, "GET", "/movies/1?includeDeleted=true", ""), &movie)
+	if movie.DeletedAt == nil || movie.Version != 2 {
+		t.Errorf("GET ?includeDeleted=true = %+v, want the movie deleted at version 2", movie)
+	}
+	var movies []Movie
+	decodeBody(t, do(t, h, "GET", "/movies", ""), &movies)
+	if len(movies) != len(seed.Movies)-1 {
+		t.Errorf("GET /movies has %d movies, want %d", len(movies), len(seed.Movies)-1)
+	}
+	decodeBody(t, do(t, h, "GET", "/movies?includeDeleted=true", ""), &movies)
+	if len(movies) != len(seed.Movies) || movies[0].DeletedAt == nil {
+		t.Errorf("GET /movies?includeDeleted=true has %d movies, want all %d", len(movies), len(seed.Movies))
+	}
+
+	restore := func(id string, want int) Movie {
+		t.Helper()
+		resp := do(t, h, "POST", "/movies/"+id+":restore", "")
+		if resp.StatusCode != want {
+			t.Fatalf("restore %s: status %d, want %d", id, resp.StatusCode, want)
+		}
+		var movie Movie
+		decodeBody(t, resp, &movie)
+		return movie
+	}
+	if movie := restore("1", http.StatusOK); movie.DeletedAt != nil || movie.Version != 3 {
+		t.Errorf("restored movie = %+v, want it live at version 3", movie)
+	}
+	// restoring a live movie changes nothing
+	if movie := restore("1", http.StatusOK); movie.Version != 3 {
+		t.Errorf("restoring again gave version %d, want 3", movie.Version)
+	}
+	var events []MovieEvent
+	decodeBody(t, do(t, h, "GET", "/movies/1/history", ""), &events)
+	if len(events) != 3 || events[2].Action != actionRestore || events[2].Revision != 3 {
+		t.Errorf("history = %+v, want a create, a delete and one restore", events)
+	}
+	restore("99", http.StatusNotFound)
+
+	// nor can a movie whose director has gone
+	if resp := do(t, h, "DELETE", "/directors/2?cascade=true", ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE /directors/2: status %d, want 204", resp.StatusCode)
+	}
+	restore("2", http.StatusConflict)
+}
+
+func TestPurgeDeleted(t *testing.T) {
+	store := newMemoryStore(seed)
+	for _, id := range []string{"1", "2"} {
+		if err := store.Delete(id, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// movie 1 was deleted long ago
+	store.movies[0].DeletedAt = new(time.Time)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// runs once, then sees ctx is done
+	purgeDeleted(ctx, store, time.Hour, time.Hour)
+	if _, err := store.Get("1"); !errors.Is(err, ErrMovieNotFound) {
+		t.Errorf("movie deleted long ago: %v, want it purged", err)
+	}
+	if _, err := store.Get("2"); err != nil {
+		t.Errorf("movie deleted just now: %v, want it kept", err)
+	}
+
+	// and then every interval
+	ctx, cancel = context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		purgeDeleted(ctx, store, 0, 10*time.Millisecond)
+		close(done)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := store.Get("2"); errors.Is(err, ErrMovieNotFound) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("movie 2 was never purged")
+		}
+	}
+	cancel()
+	<-done
+	if movies, _ := store.List(); len(movies) != len(seed.Movies)-2 {
+		t.Errorf("%d movies left, want %d", len(movies), len(seed.Movies)-2)
+	}
+}
+
+func TestPurgeInterval(t *testing.T) {
+	for _, tt := range []struct {
+		retention, want time.Duration
+	}{
+		{30 * 24 * time.Hour, time.Hour},
+		{time.Hour, 6 * time.Minute},
+		{time.Minute, 6 * time.Second},
+		{5 * time.Second, time.Second},
+		{0, time.Second},
+	} {
+		if got := purgeInterval(tt.retention); got != tt.want {
+			t.Errorf("purgeInterval(%v) = %v, want %v", tt.retention, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxBodyBytes caps the size of a request body. A movie is a few hundred
+// bytes, so 1 MB is plenty and stops clients from streaming us garbage.
+const maxBodyBytes = 1 << 20
+
+const (
+	maxIDLength    = 64
+	maxTitleLength = 200
+	maxNameLength  = 100
+	maxGenreLength = 50
+	maxRuntime     = 1000 // minutes
+	maxGenres      = 20
+	maxCast        = 200
+	maxRatings     = 10000
+	minScore       = 1
+	maxScore       = 5
+)
+
+// fieldError describes one invalid field in a request body.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// decodeJSON reads exactly one JSON object from the request body into dst.
+// Broken JSON, trailing data and bodies over maxBodyBytes are rejected: it
+// writes the error response itself and returns false. Fields dst has no
+// room for are returned, so they can be reported with the other invalid
+// fields.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst any) ([]fieldError, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	dec := json.NewDecoder(r.Body)
+
+	var raw json.RawMessage
+	err := dec.Decode(&raw)
+	if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("body must contain a single JSON object")
+	}
+	if err == nil {
+		err = json.Unmarshal(raw, dst)
+	}
+	if err == nil {
+		return unknownFields(raw, reflect.TypeOf(dst)), true
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, codeTooLarge,
+			fmt.Sprintf("request body must not be larger than %d bytes", tooLarge.Limit))
+		return nil, false
+	}
+	writeError(w, http.StatusBadRequest, codeBadRequest, "invalid JSON body: "+err.Error())
+	return nil, false
+}
+
+// unknownFields returns an error for every key of the JSON in data that a
+// value of type t has no field for, like a typo in "titel" or
+// "cast[0].actr". Keys are matched the way encoding/json matches them.
+func unknownFields(data []byte, t reflect.Type) []fieldError {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil
+	}
+	var errs []fieldError
+	var walk func(v any, t reflect.Type, path string)
+	walk = func(v any, t reflect.Type, path string) {
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.Struct:
+			obj, ok := v.(map[string]any)
+			if !ok || reflect.PointerTo(t).Implements(jsonUnmarshaler) {
+				return
+			}
+			fields := jsonFields(t)
+			for key, value := range obj {
+				field, ok := fields[strings.ToLower(key)]
+				if !ok {
+					errs = append(errs, fieldError{Field: path + key, Message: "is not a known field"})
+					continue
+				}
+				walk(value, field.Type, path+key+".")
+			}
+		case reflect.Slice, reflect.Array:
+			list, _ := v.([]any)
+			for i, value := range list {
+				walk(value, t.Elem(), fmt.Sprintf("%s[%d].", strings.TrimSuffix(path, "."), i))
+			}
+		}
+	}
+	walk(v, t, "")
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+	return errs
+}
+
+var jsonUnmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// jsonFields returns the fields of struct type t by lower-cased JSON name,
+// including those of embedded structs.
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		switch {
+		case name == "-" || !f.IsExported() && !f.Anonymous:
+			continue
+		case f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct:
+			for n, ef := range jsonFields(f.Type) {
+				fields[n] = ef
+			}
+			continue
+		case name == "":
+			name = f.Name
+		}
+		fields[strings.ToLower(name)] = f
+	}
+	return fields
+}
+
+// invalidISBN is the message of a movie whose ISBN fails the checksum.
+const invalidISBN = "must be a valid ISBN-10 or ISBN-13"
+
+// validateUpdate checks a movie that is to replace the one with the given
+// ID. Movies stored before ISBNs were checked may have ISBNs that fail the
+// checksum; they can still be updated as long as the ISBN stays the same.
+func (s *server) validateUpdate(id string, movie Movie) []fieldError {
+	errs := movie.validate()
+	for i, e := range errs {
+		if e.Field != "isbn" || e.Message != invalidISBN {
+			continue
+		}
+		if current, err := s.store.Get(id); err == nil && current.ISBN == movie.ISBN {
+			errs = append(errs[:i], errs[i+1:]...)
+		}
+		break
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// writeValidationError sends a 422 listing every field that failed.
+func writeValidationError(w http.ResponseWriter, errs []fieldError) {
+	writeAPIError(w, http.StatusUnprocessableEntity, apiError{
+		Code:    codeValidation,
+		Message: "request body has invalid fields",
+		Details: errs,
+	})
+}
+
+// validate checks every field of the movie and returns all the problems
+// found, or nil if the movie is valid.
+func (m Movie) validate() []fieldError {
+	var errs []fieldError
+	add := func(field, message string) {
+		errs = append(errs, fieldError{Field: field, Message: message})
+	}
+
+	errs = append(errs, validateID("id", m.ID)...)
+
+	switch {
+	case strings.TrimSpace(m.Title) == "":
+		add("title", "is required")
+	case utf8.RuneCountInString(m.Title) > maxTitleLength:
+		add("title", fmt.Sprintf("must be at most %d characters", maxTitleLength))
+	}
+
+	switch {
+	case strings.TrimSpace(m.ISBN) == "":
+		add("isbn", "is required")
+	case !validISBN(m.ISBN):
+		add("isbn", invalidISBN)
+	}
+
+	// directorId wins; an embedded director is only looked at without one
+	switch {
+	case m.DirectorID != "":
+		errs = append(errs, validateID("directorId", m.DirectorID)...)
+	case m.Director == nil:
+		add("directorId", "is required")
+	case m.Director.ID != "":
+		errs = append(errs, validateID("director.id", m.Director.ID)...)
+	default:
+		errs = append(errs, m.Director.validate("director.")...)
+	}
+
+	if m.ReleaseDate != "" {
+		if _, err := time.Parse(time.DateOnly, m.ReleaseDate); err != nil {
+			add("releaseDate", "must be a date like 2010-07-16")
+		}
+	}
+	if m.Runtime < 0 || m.Runtime > maxRuntime {
+		add("runtime", fmt.Sprintf("must be between 0 and %d minutes", maxRuntime))
+	}
+
+	if len(m.Genres) > maxGenres {
+		add("genres", fmt.Sprintf("must have at most %d entries", maxGenres))
+	}
+	seen := map[string]bool{}
+	for i, genre := range m.Genres {
+		field := fmt.Sprintf("genres[%d]", i)
+		switch {
+		case strings.TrimSpace(genre) == "":
+			add(field, "must not be empty")
+		case utf8.RuneCountInString(genre) > maxGenreLength:
+			add(field, fmt.Sprintf("must be at most %d characters", maxGenreLength))
+		case seen[strings.ToLower(genre)]:
+			add(field, "is listed twice")
+		}
+		seen[strings.ToLower(genre)] = true
+	}
+
+	if len(m.Cast) > maxCast {
+		add("cast", fmt.Sprintf("must have at most %d entries", maxCast))
+	}
+	for i, member := range m.Cast {
+		field := fmt.Sprintf("cast[%d]", i)
+		switch {
+		case strings.TrimSpace(member.Actor) == "":
+			add(field+".actor", "is required")
+		case utf8.RuneCountInString(member.Actor) > maxNameLength:
+			add(field+".actor", fmt.Sprintf("must be at most %d characters", maxNameLength))
+		}
+		if utf8.RuneCountInString(member.Role) > maxNameLength {
+			add(field+".role", fmt.Sprintf("must be at most %d characters", maxNameLength))
+		}
+	}
+
+	if len(m.Ratings) > maxRatings {
+		add("ratings", fmt.Sprintf("must have at most %d entries", maxRatings))
+	}
+	users := map[string]bool{}
+	for i, rating := range m.Ratings {
+		for _, e := range rating.validate() {
+			add(fmt.Sprintf("ratings[%d].%s", i, e.Field), e.Message)
+		}
+		if users[rating.User] {
+			add(fmt.Sprintf("ratings[%d].user", i), "has already rated this movie")
+		}
+		users[rating.User] = true
+	}
+
+	return errs
+}
+
+// validate checks a single rating.
+func (r Rating) validate() []fieldError {
+	var errs []fieldError
+	switch {
+	case strings.TrimSpace(r.User) == "":
+		errs = append(errs, fieldError{Field: "user", Message: "is required"})
+	case utf8.RuneCountInString(r.User) > maxNameLength:
+		errs = append(errs, fieldError{Field: "user", Message: fmt.Sprintf("must be at most %d characters", maxNameLength)})
+	}
+	if r.Score < minScore || r.Score > maxScore {
+		errs = append(errs, fieldError{Field: "score", Message: fmt.Sprintf("must be between %d and %d", minScore, maxScore)})
+	}
+	return errs
+}
+
+// validate checks a director's fields. prefix is put in front of the field
+// names, for directors embedded in a movie.
+func (d Director) validate(prefix string) []fieldError {
+	errs := validateID(prefix+"id", d.ID)
+	for _, name := range []struct{ field, value string }{
+		{prefix + "firstName", d.FirstName},
+		{prefix + "lastName", d.LastName},
+	} {
+		switch {
+		case strings.TrimSpace(name.value) == "":
+			errs = append(errs, fieldError{Field: name.field, Message: "is required"})
+		case utf8.RuneCountInString(name.value) > maxNameLength:
+			errs = append(errs, fieldError{Field: name.field, Message: fmt.Sprintf("must be at most %d characters", maxNameLength)})
+		}
+	}
+	return errs
+}
+
+// validateID checks an optional ID. IDs end up in URLs, so they are kept to
+// a small, URL-safe alphabet.
+func validateID(field, id string) []fieldError {
+	switch {
+	case len(id) > maxIDLength:
+		return []fieldError{{Field: field, Message: fmt.Sprintf("must be at most %d characters", maxIDLength)}}
+	case strings.IndexFunc(id, invalidIDRune) >= 0:
+		return []fieldError{{Field: field, Message: "may only contain letters, digits, '-' and '_'"}}
+	}
+	return nil
+}
+
+func invalidIDRune(r rune) bool {
+	return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_')
+}
+
+// validISBN reports whether s is an ISBN-10 or ISBN-13 with a correct check
+// digit. Hyphens and spaces are ignored.
+func validISBN(s string) bool {
+	s = strings.NewReplacer("-", "", " ", "").Replace(s)
+
+	switch len(s) {
+	case 10:
+		// weights 10..1, the last digit may be X (= 10)
+		sum := 0
+		for i, c := range s {
+			var d int
+			switch {
+			case c >= '0' && c <= '9':
+				d = int(c - '0')
+			case (c == 'X' || c == 'x') && i == 9:
+				d = 10
+			default:
+				return false
+			}
+			sum += d * (10 - i)
+		}
+		return sum%11 == 0
+	case 13:
+		// weights alternate 1, 3, 1, 3...
+		sum := 0
+		for i, c := range s {
+			if c < '0' || c > '9' {
+				return false
+			}
+			d := int(c - '0')
+			if i%2 == 1 {
+				d *= 3
+			}
+			sum += d
+		}
+		return sum%10 == 0
+	}
+	return false
+}
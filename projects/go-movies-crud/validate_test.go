@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestUnknownFieldsAreValidationErrors(t *testing.T) {
+	h := newTestServer(t).routes()
+	resp := do(t, h, "POST", "/movies", `{"titel":"Heat","isbn":"9780345341464","directorId":"1","cast":[{"actr":"Al Pacino"}]}`)
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("status %d, want 422", resp.StatusCode)
+	}
+	var body errorResponse
+	decodeBody(t, resp, &body)
+	want := []fieldError{
+		{Field: "cast[0].actr", Message: "is not a known field"},
+		{Field: "titel", Message: "is not a known field"},
+		{Field: "title", Message: "is required"},
+		{Field: "cast[0].actor", Message: "is required"},
+	}
+	if !reflect.DeepEqual(body.Error.Details, want) {
+		t.Errorf("details = %+v, want %+v", body.Error.Details, want)
+	}
+}
+
+// Stores created before ISBNs were checked hold ISBNs that fail the
+// checksum. Their movies can still be changed, as long as the ISBN isn't.
+func TestLegacyISBNKeptOnUpdate(t *testing.T) {
+	legacy := catalog{
+		Directors: []Director{{ID: "1", FirstName: "George", LastName: "Lucas"}},
+		Movies:    []Movie{{ID: "1", ISBN: "438227", Title: "Star Wars", DirectorID: "1"}},
+	}
+	var store Store = newMemoryStore(legacy)
+	index, err := newSearchIndex(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newServer(indexedStore{Store: store, index: index}, uuidGenerator{}, index).routes()
+
+	if resp := do(t, h, "PATCH", "/movies/1", `{"title":"Star Wars: A New Hope"}`); resp.StatusCode != http.StatusOK {
+		t.Errorf("PATCH title: status %d, want 200", resp.StatusCode)
+	}
+	if resp := do(t, h, "PUT", "/movies/1", `{"isbn":"438227","title":"Star Wars","directorId":"1"}`); resp.StatusCode != http.StatusOK {
+		t.Errorf("PUT same ISBN: status %d, want 200", resp.StatusCode)
+	}
+	if resp := do(t, h, "PATCH", "/movies/1", `{"isbn":"438228"}`); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("PATCH new invalid ISBN: status %d, want 422", resp.StatusCode)
+	}
+}
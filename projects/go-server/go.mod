@@ -0,0 +1,7 @@
+module go-server
+
+go 1.21
+
+require go-config v0.0.0
+
+replace go-config => ../go-config
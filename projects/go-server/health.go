@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+)
+
+// probes are the endpoints an orchestrator polls:
+//
+//	GET /healthz  200 while the process is up
+//	GET /readyz   200 while the server can take requests, 503 otherwise
+//	GET /version  the module version and VCS revision of the binary
+type probes struct {
+	staticDir string
+	stopping  atomic.Bool // set once a shutdown starts
+}
+
+var probePaths = map[string]bool{"/healthz": true, "/readyz": true, "/version": true}
+
+func (p *probes) register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", p.healthz)
+	mux.HandleFunc("/readyz", p.readyz)
+	mux.HandleFunc("/version", p.version)
+}
+
+// skipRateLimit sends the probes straight to mux, and everything else to
+// limited, so an orchestrator is never rate limited.
+func skipRateLimit(mux *http.ServeMux, limited http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if probePaths[r.URL.Path] {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		limited.ServeHTTP(w, r)
+	})
+}
+
+// onlyGet answers 405 to anything but GET and HEAD, and reports whether
+// the request may go on.
+func onlyGet(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		httpError(w, "Method is not supported.", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+func (p *probes) healthz(w http.ResponseWriter, r *http.Request) {
+	if onlyGet(w, r) {
+		fmt.Fprintln(w, "ok")
+	}
+}
+
+// readyz fails once a shutdown has started, so load balancers stop sending
+// requests, and while the static directory is missing.
+func (p *probes) readyz(w http.ResponseWriter, r *http.Request) {
+	if !onlyGet(w, r) {
+		return
+	}
+	var problems []string
+	if p.stopping.Load() {
+		problems = append(problems, "shutting down")
+	}
+	if info, err := os.Stat(p.staticDir); err != nil || !info.IsDir() {
+		problems = append(problems, "static directory missing")
+	}
+	if len(problems) > 0 {
+		httpError(w, "not ready: "+strings.Join(problems, ", "), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ready")
+}
+
+// version prints the build info Go embeds in the binary. The VCS lines are
+// missing when it was built outside a checkout or with -buildvcs=false.
+func (p *probes) version(w http.ResponseWriter, r *http.Request) {
+	if !onlyGet(w, r) {
+		return
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		fmt.Fprintln(w, "version: unknown")
+		return
+	}
+	fmt.Fprintf(w, "module: %s\nversion: %s\ngo: %s\n", bi.Main.Path, bi.Main.Version, bi.GoVersion)
+	for _, setting := range bi.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			fmt.Fprintf(w, "revision: %s\n", setting.Value)
+		case "vcs.time":
+			fmt.Fprintf(w, "revision time: %s\n", setting.Value)
+		case "vcs.modified":
+			fmt.Fprintf(w, "modified: %s\n", setting.Value)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProbes(t *testing.T) {
+	p := &probes{staticDir: t.TempDir()}
+	mux := http.NewServeMux()
+	p.register(mux)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+
+	probe := func(method, path string, want int, body string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		if rec.Code != want || !strings.Contains(rec.Body.String(), body) {
+			t.Errorf("%s %s: %d %q, want %d with %q", method, path, rec.Code, rec.Body.String(), want, body)
+		}
+	}
+	probe("GET", "/healthz", http.StatusOK, "ok")
+	probe("POST", "/healthz", http.StatusMethodNotAllowed, "")
+	probe("GET", "/version", http.StatusOK, "go: go")
+	probe("GET", "/readyz", http.StatusOK, "ready")
+
+	p.stopping.Store(true)
+	probe("GET", "/readyz", http.StatusServiceUnavailable, "shutting down")
+	p.stopping.Store(false)
+	p.staticDir = filepath.Join(p.staticDir, "missing")
+	probe("GET", "/readyz", http.StatusServiceUnavailable, "static directory missing")
+	probe("GET", "/healthz", http.StatusOK, "ok")
+}
+
+// The probes get through when everything else is over its rate limit.
+func TestProbesSkipRateLimit(t *testing.T) {
+	mux := http.NewServeMux()
+	(&probes{staticDir: t.TempDir()}).register(mux)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+	limits, err := parseRateLimits("*=1/h:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := skipRateLimit(mux, newRateLimiter(limits).middleware(mux))
+
+	for i, tt := range []struct {
+		path string
+		want int
+	}{
+		{"/", http.StatusOK},
+		{"/", http.StatusTooManyRequests},
+		{"/healthz", http.StatusOK},
+		{"/readyz", http.StatusOK},
+		{"/version", http.StatusOK},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%d: GET %s: status %d, want %d", i, tt.path, rec.Code, tt.want)
+		}
+	}
+}
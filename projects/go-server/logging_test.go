@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newLoggedMux returns a mux with a few routes inside logRequests, and the
+// buffer its JSON logs go to.
+func newLoggedMux(t *testing.T) (http.Handler, *bytes.Buffer) {
+	t.Helper()
+	var logs bytes.Buffer
+	logger, err := newLogger(&logs, "json", "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/files/", func(w http.ResponseWriter, r *http.Request) {
+		logger.InfoContext(r.Context(), "serving a file")
+		io.WriteString(w, "contents")
+	})
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		httpError(w, "500 broken.", http.StatusInternalServerError)
+	})
+	return logRequests(logger, mux, mux), &logs
+}
+
+func get(h http.Handler, path, requestID string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	if requestID != "" {
+		req.Header.Set(requestIDHeader, requestID)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func logLines(t *testing.T, logs *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	dec := json.NewDecoder(logs)
+	for dec.More() {
+		var line map[string]any
+		if err := dec.Decode(&line); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// The request ID a client sends, or the one made up for it, is sent back
+// and appears in error messages and in every log line of the request.
+func TestRequestID(t *testing.T) {
+	h, logs := newLoggedMux(t)
+
+	rec := get(h, "/files/a.txt", "trace-42")
+	if got := rec.Header().Get(requestIDHeader); got != "trace-42" {
+		t.Errorf("%s = %q, want trace-42", requestIDHeader, got)
+	}
+	lines := logLines(t, logs)
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want 2", len(lines))
+	}
+	for _, line := range lines {
+		if line["requestId"] != "trace-42" {
+			t.Errorf("%q line has requestId %v, want trace-42", line["msg"], line["requestId"])
+		}
+	}
+
+	if rec := get(h, "/broken", "trace-43"); !strings.Contains(rec.Body.String(), "(request trace-43)") {
+		t.Errorf("error message %q doesn't name the request", rec.Body.String())
+	}
+
+	for _, id := range []string{"", "two words", strings.Repeat("x", 129)} {
+		got := get(h, "/files/a.txt", id).Header().Get(requestIDHeader)
+		if got == id || len(got) != 32 {
+			t.Errorf("request ID %q came back as %q, want a new one", id, got)
+		}
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	h, logs := newLoggedMux(t)
+	get(h, "/files/a.txt", "")
+	get(h, "/broken", "")
+
+	lines := logLines(t, logs)
+	if len(lines) != 3 {
+		t.Fatalf("got %d log lines, want 3", len(lines))
+	}
+	want := map[string]any{
+		"level": "INFO", "msg": "request", "method": "GET", "route": "/files/",
+		"path": "/files/a.txt", "status": 200.0, "bytes": 8.0, "ip": "192.0.2.1",
+	}
+	for key, value := range want {
+		if lines[1][key] != value {
+			t.Errorf("%s = %v, want %v", key, lines[1][key], value)
+		}
+	}
+	if _, ok := lines[1]["latency"].(float64); !ok {
+		t.Errorf("latency = %v, want a number", lines[1]["latency"])
+	}
+	if lines[2]["level"] != "ERROR" || lines[2]["status"] != 500.0 {
+		t.Errorf("a 500 was logged at %v with status %v", lines[2]["level"], lines[2]["status"])
+	}
+}
@@ -4,13 +4,79 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"go-config"
 )
 
 func main() {
-	fileServer := http.FileServer(http.Dir("./static"))
+	// every flag can also be set with an environment variable or in a
+	// config file, see the go-config module
+	addr := flag.String("addr", ":8080", "address to listen on")
+	staticDir := flag.String("static", "./static", "directory of the static files")
+	readTimeout := flag.Duration("read-timeout", 10*time.Second, "how long a client may take to send a request")
+	writeTimeout := flag.Duration("write-timeout", 30*time.Second, "how long writing a response may take")
+	idleTimeout := flag.Duration("idle-timeout", 2*time.Minute, "how long an idle keep-alive connection is kept open")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 15*time.Second, "how long to wait for requests in progress when shutting down")
+	shutdownDelay := flag.Duration("shutdown-delay", 0, "how long to keep serving, with /readyz failing, before shutting down")
+	rateLimits := flag.String("rate-limits", "", "per-path rate limits for each client IP, as path=rate:burst,... (empty = no rate limiting)")
+	logFormat := flag.String("log-format", "text", "log format: text or json")
+	logLevel := flag.String("log-level", "info", "lowest level logged: debug, info, warn or error")
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:], "SERVER_")
+	if err != nil {
+		log.Fatalf("invalid config:\n%v", err)
+	}
+
+	// check every setting before starting
+	var problems []string
+	if _, _, err := net.SplitHostPort(*addr); err != nil {
+		problems = append(problems, fmt.Sprintf("addr: %q must look like :8080 or 127.0.0.1:8080", *addr))
+	}
+	if info, err := os.Stat(*staticDir); err != nil || !info.IsDir() {
+		problems = append(problems, fmt.Sprintf("static: %q is not a directory", *staticDir))
+	}
+	for name, d := range map[string]time.Duration{
+		"read-timeout": *readTimeout, "write-timeout": *writeTimeout,
+		"idle-timeout": *idleTimeout, "shutdown-timeout": *shutdownTimeout,
+	} {
+		if d <= 0 {
+			problems = append(problems, name+": must be more than 0")
+		}
+	}
+	if *shutdownDelay < 0 {
+		problems = append(problems, "shutdown-delay: must not be negative")
+	}
+	limits, err := parseRateLimits(*rateLimits)
+	if err != nil {
+		problems = append(problems, "rate-limits: "+err.Error())
+	}
+	logger, err := newLogger(os.Stderr, *logFormat, *logLevel)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		log.Fatalf("invalid config:\n%s", strings.Join(problems, "\n"))
+	}
+	if cfg.Print {
+		cfg.Write(os.Stdout)
+		return
+	}
+	slog.SetDefault(logger)
+
+	fileServer := http.FileServer(http.Dir(*staticDir))
 	http.Handle("/", fileServer)
 
 	http.HandleFunc("/form", func(w http.ResponseWriter, r *http.Request) {
@@ -28,13 +94,13 @@ func main() {
 	http.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
 		// Check that the URL path is /hello
 		if r.URL.Path != "/hello" {
-			http.Error(w, "404 not found.", http.StatusNotFound)
+			httpError(w, "404 not found.", http.StatusNotFound)
 			return
 		}
 
 		// Check that the request method is GET
 		if r.Method != "GET" {
-			http.Error(w, "Method is not supported.", http.StatusNotFound)
+			httpError(w, "Method is not supported.", http.StatusNotFound)
 			return
 		}
 
@@ -42,9 +108,46 @@ func main() {
 		fmt.Fprintf(w, "Hello!")
 	})
 
-	fmt.Println("Starting server on :8080")
+	health := &probes{staticDir: *staticDir}
+	health.register(http.DefaultServeMux)
+	limited := newRateLimiter(limits).middleware(http.DefaultServeMux)
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           logRequests(logger, http.DefaultServeMux, skipRateLimit(http.DefaultServeMux, limited)),
+		ReadHeaderTimeout: *readTimeout,
+		ReadTimeout:       *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
 
-	if err := http.ListenAndServe(":8080", nil); err != nil {
-		log.Fatal(err)
+	// Ctrl+C or SIGTERM stops the server gracefully
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	slog.Info("starting server", "addr", *addr)
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	<-ctx.Done()
+	stop() // a second signal kills the server right away
+
+	// fail /readyz for a while so load balancers stop sending requests,
+	// then stop accepting connections and let the requests in progress finish
+	health.stopping.Store(true)
+	if *shutdownDelay > 0 {
+		slog.Info("shutting down, still serving while load balancers catch up", "delay", *shutdownDelay)
+		time.Sleep(*shutdownDelay)
+	}
+	slog.Info("shutting down")
+	drainCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(drainCtx); err != nil {
+		slog.Warn("requests still running after the drain deadline, closing them", "drain", *shutdownTimeout)
+		server.Close()
 	}
+	slog.Info("server stopped")
 }
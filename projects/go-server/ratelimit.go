@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimit lets a client make burst requests at once, and then rate
+// requests a second as its bucket fills up again.
+type rateLimit struct {
+	rate  float64 // 0 means no limit
+	burst int
+}
+
+// routeLimit is the limit of the requests for path, or for every path
+// starting with it when prefix is set.
+type routeLimit struct {
+	path   string
+	prefix bool
+	limit  rateLimit
+}
+
+func (rl routeLimit) matches(path string) bool {
+	if rl.prefix {
+		return strings.HasPrefix(path, rl.path)
+	}
+	return path == rl.path
+}
+
+// parseRateLimits parses a comma separated list of path=rate:burst, such as
+// "/form=1/s:5,/hello=off,*=10/s:20". A path ending in * covers every path
+// starting with it, and the first path that matches a request sets its
+// limit. Rates are per second, minute or hour: 10/s, 600/m, 1000/h.
+func parseRateLimits(spec string) ([]routeLimit, error) {
+	var limits []routeLimit
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		path, value, ok := strings.Cut(entry, "=")
+		if !ok || path == "" {
+			return nil, fmt.Errorf("rate limit %q must look like /path=10/s:20", entry)
+		}
+		rl := routeLimit{path: path}
+		if strings.HasSuffix(path, "*") {
+			rl.path, rl.prefix = strings.TrimSuffix(path, "*"), true
+		}
+		if value != "off" {
+			rate, burst, _ := strings.Cut(value, ":")
+			count, unit, _ := strings.Cut(rate, "/")
+			n, err := strconv.ParseFloat(count, 64)
+			per := map[string]float64{"s": 1, "m": 60, "h": 3600}[unit]
+			b, burstErr := strconv.Atoi(burst)
+			if err != nil || n <= 0 || math.IsInf(n, 0) || per == 0 || burstErr != nil || b < 1 {
+				return nil, fmt.Errorf("rate limit %q must look like /path=10/s:20 or /path=off", entry)
+			}
+			rl.limit = rateLimit{rate: n / per, burst: b}
+		}
+		limits = append(limits, rl)
+	}
+	return limits, nil
+}
+
+// rateLimiter keeps a token bucket for every client IP on every route
+// limit. Buckets that have filled up again are no different from new ones,
+// so they are dropped every sweepInterval to keep memory in check.
+type rateLimiter struct {
+	routes []routeLimit
+
+	mu        sync.Mutex
+	buckets   map[bucketKey]*bucket
+	lastSweep time.Time
+}
+
+type bucketKey struct {
+	route int // index in routes
+	ip    string
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+const sweepInterval = time.Minute
+
+func newRateLimiter(routes []routeLimit) *rateLimiter {
+	return &rateLimiter{routes: routes, buckets: map[bucketKey]*bucket{}, lastSweep: time.Now()}
+}
+
+// middleware answers 429 Too Many Requests to clients that have used up
+// their bucket, with Retry-After and X-RateLimit-* headers saying when they
+// can come back.
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := -1
+		for i, rl := range l.routes {
+			if rl.matches(r.URL.Path) {
+				route = i
+				break
+			}
+		}
+		if route < 0 || l.routes[route].limit.rate == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		limit := l.routes[route].limit
+		allowed, remaining, retry, reset := l.take(bucketKey{route, ip}, limit, time.Now())
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit.burst))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset))))
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry))))
+			httpError(w, "429 too many requests.", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// take takes a token from a bucket, if there is one. It also returns the
+// whole tokens left, and the seconds until there is a token again and until
+// the bucket is full.
+func (l *rateLimiter) take(key bucketKey, limit rateLimit, now time.Time) (allowed bool, remaining int, retry, reset float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		for k, b := range l.buckets {
+			lim := l.routes[k.route].limit
+			if b.tokens+now.Sub(b.last).Seconds()*lim.rate >= float64(lim.burst) {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.burst), b.tokens+now.Sub(b.last).Seconds()*limit.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		allowed = true
+	} else {
+		retry = (1 - b.tokens) / limit.rate
+	}
+	reset = (float64(limit.burst) - b.tokens) / limit.rate
+	return allowed, int(b.tokens), retry, reset
+}
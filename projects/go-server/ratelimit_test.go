@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := parseRateLimits(" /form=2/s:5, /hello=off,/api/*=600/m:10,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []routeLimit{
+		{path: "/form", limit: rateLimit{rate: 2, burst: 5}},
+		{path: "/hello"},
+		{path: "/api/", prefix: true, limit: rateLimit{rate: 10, burst: 10}},
+	}
+	if len(limits) != len(want) {
+		t.Fatalf("got %d limits, want %d", len(limits), len(want))
+	}
+	for i := range want {
+		if limits[i] != want[i] {
+			t.Errorf("limit %d = %+v, want %+v", i, limits[i], want[i])
+		}
+	}
+
+	if limits, err := parseRateLimits(""); err != nil || len(limits) != 0 {
+		t.Errorf(`parseRateLimits("") = %v, %v; want no limits`, limits, err)
+	}
+	for _, spec := range []string{"/form", "=1/s:1", "/form=1/s", "/form=1/d:1", "/form=0/s:1", "/form=1/s:0", "/form=x/s:1"} {
+		if _, err := parseRateLimits(spec); err == nil {
+			t.Errorf("parseRateLimits(%q) accepted it", spec)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limits, err := parseRateLimits("/form=1/h:2,/hello=off")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := newRateLimiter(limits).middleware(ok)
+	get := func(path, ip string) *http.Response {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Result()
+	}
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		resp := get("/form", "192.0.2.1")
+		if resp.StatusCode != want {
+			t.Fatalf("request %d: status %d, want %d", i+1, resp.StatusCode, want)
+		}
+		if got := resp.Header.Get("X-RateLimit-Limit"); got != "2" {
+			t.Errorf("request %d: X-RateLimit-Limit = %q, want 2", i+1, got)
+		}
+		if want == http.StatusTooManyRequests && resp.Header.Get("Retry-After") != "3600" {
+			t.Errorf("Retry-After = %q, want 3600", resp.Header.Get("Retry-After"))
+		}
+	}
+	// other clients, and paths that are off or not listed, are not held back
+	if resp := get("/form", "192.0.2.2"); resp.StatusCode != http.StatusOK {
+		t.Errorf("another IP: status %d, want 200", resp.StatusCode)
+	}
+	for _, path := range []string{"/hello", "/index.html"} {
+		for i := 0; i < 5; i++ {
+			if resp := get(path, "192.0.2.1"); resp.StatusCode != http.StatusOK || resp.Header.Get("X-RateLimit-Limit") != "" {
+				t.Fatalf("%s: status %d with X-RateLimit-Limit %q, want no limit", path, resp.StatusCode, resp.Header.Get("X-RateLimit-Limit"))
+			}
+		}
+	}
+}
+
+// A bucket fills up again at the rate of its limit, and full buckets are
+// dropped while partly drained ones are kept.
+func TestRateLimiterRefills(t *testing.T) {
+	l := newRateLimiter([]routeLimit{
+		{path: "/", prefix: true, limit: rateLimit{rate: 1, burst: 2}},
+		{path: "/slow", limit: rateLimit{rate: 1.0 / 3600, burst: 2}},
+	})
+	key := bucketKey{0, "192.0.2.1"}
+	now := time.Now()
+	limit := l.routes[0].limit
+	l.take(key, limit, now)
+	l.take(key, limit, now)
+	if allowed, _, retry, _ := l.take(key, limit, now); allowed || retry != 1 {
+		t.Fatalf("third request at once: allowed %v, retry %v; want refused, retry 1", allowed, retry)
+	}
+	if allowed, _, _, _ := l.take(key, limit, now.Add(time.Second)); !allowed {
+		t.Fatal("a second later: refused")
+	}
+
+	slow := bucketKey{1, "192.0.2.1"}
+	l.take(key, limit, now.Add(sweepInterval+time.Second))
+	l.take(slow, l.routes[1].limit, now.Add(sweepInterval+time.Second))
+	// the next sweep finds key full again, and slow still a token short
+	l.take(bucketKey{0, "192.0.2.2"}, limit, now.Add(2*sweepInterval+time.Second))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.buckets[key]; ok {
+		t.Error("a full bucket was not dropped")
+	}
+	if _, ok := l.buckets[slow]; !ok {
+		t.Error("a partly drained bucket was dropped")
+	}
+}